@@ -0,0 +1,117 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/afiestas/gluetun-sync/lib"
+)
+
+// syncCommand asks the watch loop to synchronize groups, every group when
+// there are none. A port, if set, replaces the current one first and can't be
+// given with groups.
+type syncCommand struct {
+	port   uint16
+	groups []string
+	reply  chan syncReply
+}
+
+type syncReply struct {
+	result lib.SyncResult
+	code   int
+	err    error
+}
+
+// syncCommands is how the control api reaches the watch loop
+var syncCommands = make(chan syncCommand)
+
+// handleAPI registers the control api endpoints with handle
+func handleAPI(handle func(path string, handler http.Handler)) {
+	authenticated := func(handler http.HandlerFunc) http.Handler {
+		return lib.RequireToken(func() (string, error) {
+			token, err := currentConfig().APIToken()
+			lib.AddSecret(token)
+			return token, err
+		}, handler)
+	}
+
+	handle("/status", authenticated(apiStatus))
+	handle("/sync", authenticated(apiSync))
+	handle("/port", authenticated(apiPort))
+	handle("/config", authenticated(apiConfig))
+}
+
+func apiStatus(w http.ResponseWriter, r *http.Request) {
+	if lib.AllowMethod(w, r, http.MethodGet) {
+		lib.WriteJSON(w, http.StatusOK, state.Snapshot())
+	}
+}
+
+func apiConfig(w http.ResponseWriter, r *http.Request) {
+	if lib.AllowMethod(w, r, http.MethodGet) {
+		lib.WriteJSON(w, http.StatusOK, lib.RedactedSettings(currentConfig()))
+	}
+}
+
+func apiSync(w http.ResponseWriter, r *http.Request) {
+	if lib.AllowMethod(w, r, http.MethodPost) {
+		runCommand(w, r, syncCommand{groups: r.URL.Query()["group"]})
+	}
+}
+
+// apiPort takes the port from the port query param or from a {"port": 1337}
+// body
+func apiPort(w http.ResponseWriter, r *http.Request) {
+	if !lib.AllowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	body := struct {
+		Port uint16 `json:"port"`
+	}{}
+	var err error
+	if value := r.URL.Query().Get("port"); value != "" {
+		var port uint64
+		port, err = strconv.ParseUint(value, 10, 16)
+		body.Port = uint16(port)
+	} else {
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&body)
+	}
+	if err == nil && body.Port == 0 {
+		err = errors.New("the port can't be 0")
+	}
+	if err != nil {
+		lib.WriteJSON(w, http.StatusBadRequest, lib.APIError{Error: "couldn't read the port, use ?port= or {\"port\": 1337}: " + err.Error()})
+		return
+	}
+
+	runCommand(w, r, syncCommand{port: body.Port, groups: r.URL.Query()["group"]})
+}
+
+// runCommand hands command to the watch loop and answers with the result of
+// the sync, 502 if any group failed
+func runCommand(w http.ResponseWriter, r *http.Request, command syncCommand) {
+	command.reply = make(chan syncReply, 1)
+	select {
+	case syncCommands <- command:
+	case <-r.Context().Done():
+		return
+	}
+
+	select {
+	case reply := <-command.reply:
+		if reply.err != nil {
+			lib.WriteJSON(w, reply.code, lib.APIError{Error: reply.err.Error()})
+			return
+		}
+		code := http.StatusOK
+		if !reply.result.Success {
+			code = http.StatusBadGateway
+		}
+		lib.WriteJSON(w, code, reply.result)
+	case <-r.Context().Done():
+	}
+}
@@ -0,0 +1,277 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/afiestas/gluetun-sync/lib"
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+var (
+	cfgFile string
+	config  atomic.Pointer[lib.Configuration]
+	// configReloaded is signaled every time a new configuration is applied
+	configReloaded = make(chan struct{}, 1)
+)
+
+func currentConfig() lib.Configuration {
+	return *config.Load()
+}
+
+func setConfig(c lib.Configuration) {
+	applyConfig(c)
+	config.Store(&c)
+
+	select {
+	case configReloaded <- struct{}{}:
+	default:
+	}
+}
+
+// newViper returns a viper for file, if file is empty the default locations
+// are searched
+func newViper(file string) *viper.Viper {
+	v := viper.New()
+	if file != "" {
+		v.SetConfigFile(file)
+	} else {
+		v.SetConfigName("config")
+		v.AddConfigPath("/etc/gluetun-sync")
+		v.AddConfigPath(".")
+	}
+	v.SetEnvPrefix("GLUESYNC")
+	v.AutomaticEnv()
+	v.BindPFlags(rootCmd.PersistentFlags())
+
+	return v
+}
+
+// readConfig reads the config file and merges the drop-ins of the config
+// directory on top of it. The config file is optional when there is a config
+// directory.
+func readConfig(v *viper.Viper) (lib.ConfigSources, error) {
+	sources := lib.ConfigSources{Groups: map[string]string{}}
+	dir := v.GetString("config-dir")
+
+	err := v.ReadInConfig()
+	if err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if dir == "" || !errors.As(err, &notFound) {
+			return sources, err
+		}
+	}
+	sources.Main = v.ConfigFileUsed()
+
+	requests, legacy, err := lib.NormalizeRequests(v.Get("requests"))
+	if err != nil {
+		return sources, fmt.Errorf("%s: %w", sources.Main, err)
+	}
+	if legacy {
+		sources.Legacy = append(sources.Legacy, sources.Main)
+	}
+
+	if dir != "" {
+		files, err := lib.ConfigDirFiles(dir)
+		if err != nil {
+			return sources, fmt.Errorf("couldn't read config dir %w", err)
+		}
+
+		dropIns := []lib.DropIn{}
+		for _, file := range files {
+			dropIn := viper.New()
+			dropIn.SetConfigFile(file)
+			if err := dropIn.ReadInConfig(); err != nil {
+				return sources, fmt.Errorf("couldn't parse %s %w", file, err)
+			}
+			dropIns = append(dropIns, lib.DropIn{File: file, Settings: dropIn.AllSettings()})
+		}
+
+		merged, err := lib.MergeDropIns(&sources, requests, dropIns)
+		if err != nil {
+			return sources, err
+		}
+
+		requests = merged["requests"].(map[string]any)
+		delete(merged, "requests")
+		if err := v.MergeConfigMap(merged); err != nil {
+			return sources, err
+		}
+	}
+
+	// Requests can't be merged into a legacy list, they are replaced instead
+	if legacy || dir != "" {
+		v.Set("requests", requests)
+	}
+
+	return sources, nil
+}
+
+// configName describes where the configuration comes from
+func configName(v *viper.Viper, sources lib.ConfigSources) string {
+	dir := v.GetString("config-dir")
+	if dir == "" {
+		return sources.Main
+	}
+	if sources.Main == "" {
+		return dir
+	}
+	return sources.Main + " + " + dir
+}
+
+func unmarshalAndValidate(v *viper.Viper, hook mapstructure.DecodeHookFunc) (lib.Configuration, []lib.ConfigError) {
+	c := lib.Configuration{}
+	err := v.UnmarshalExact(&c, viper.DecodeHook(hook))
+	if err == nil {
+		return c, lib.ValidateConfig(c)
+	}
+
+	// Unknown keys shouldn't hide the rest of the problems
+	errs := lib.ConfigErrors(err)
+	if err := v.Unmarshal(&c, viper.DecodeHook(hook)); err == nil {
+		errs = append(errs, lib.ValidateConfig(c)...)
+	}
+	return c, errs
+}
+
+func printConfigErrors(sources lib.ConfigSources, errs []lib.ConfigError) {
+	for _, e := range errs {
+		file := sources.File(e.Path)
+		content, _ := os.ReadFile(file)
+		if line := lib.LocateLine(content, file, e.Path); line > 0 {
+			slog.Error("invalid configuration", "file", file, "line", line, "error", e)
+			continue
+		}
+		slog.Error("invalid configuration", "file", file, "error", e)
+	}
+}
+
+func printLegacyWarning(sources lib.ConfigSources) {
+	for _, file := range sources.Legacy {
+		slog.Warn("the legacy list of requests is deprecated, run gluetun-sync migrate", "file", file)
+	}
+}
+
+// applyConfig applies the settings that affect the whole process
+func applyConfig(c lib.Configuration) {
+	lib.SetSensitiveParams(c.SensitiveParams)
+	lib.AddSecrets(c)
+	state.SetGroups(lib.GroupNames(c.Requests))
+	state.SetReadyThreshold(c.ReadyThreshold)
+	history.SetFile(c.HistoryFile, lib.HistoryRetention{MaxAge: c.HistoryMaxAge, MaxEntries: c.HistoryMaxEntries})
+	if err := setupLogging(c.LogFormat, c.LogLevel); err != nil {
+		slog.Error("couldn't setup logging", "error", err)
+	}
+}
+
+// loadConfig reads and validates the configuration printing any error
+func loadConfig() (lib.Configuration, lib.ConfigSources, bool) {
+	v := newViper(cfgFile)
+	newSources, err := readConfig(v)
+	name := configName(v, newSources)
+	if err != nil {
+		slog.Error("couldn't parse config file", "config", name, "error", err)
+		return lib.Configuration{}, newSources, false
+	}
+
+	c, errs := unmarshalAndValidate(v, lib.DecodeHook())
+	if len(errs) > 0 {
+		printConfigErrors(newSources, errs)
+		return c, newSources, false
+	}
+	slog.Info("configuration loaded", "config", name, "groups", len(c.Requests))
+	printLegacyWarning(newSources)
+
+	return c, newSources, true
+}
+
+// reloadConfig parses the configuration again and applies it if it is valid,
+// the watch loop picks it up through configReloaded
+func reloadConfig() bool {
+	newConfig, _, ok := loadConfig()
+	if !ok {
+		slog.Error("can't parse new configuration, keeping the current one")
+		return false
+	}
+	setConfig(newConfig)
+
+	return true
+}
+
+// watchConfigDir calls onChange whenever a config file of dir is added,
+// removed or changed
+func watchConfigDir(dir string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if lib.IsConfigFile(event.Name) && !event.Has(fsnotify.Chmod) {
+					onChange()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("error watching config dir", "dir", dir, "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func initConfig() {
+	c, sources, ok := loadConfig()
+	if !ok {
+		os.Exit(exitConfigError)
+	}
+	applyConfig(c)
+	config.Store(&c)
+
+	// Editors write files in several steps, wait for them to settle
+	reloadTimer := time.AfterFunc(time.Hour, func() {
+		slog.Info("configuration changed")
+		reloadConfig()
+	})
+	reloadTimer.Stop()
+	scheduleReload := func() {
+		reloadTimer.Reset(time.Second * 1)
+	}
+
+	if sources.Main != "" {
+		v := newViper(sources.Main)
+		v.OnConfigChange(func(e fsnotify.Event) { scheduleReload() })
+		v.WatchConfig()
+	}
+	if c.ConfigDir != "" {
+		if err := watchConfigDir(c.ConfigDir, scheduleReload); err != nil {
+			slog.Error("couldn't watch config dir", "dir", c.ConfigDir, "error", err)
+		}
+	}
+
+	if c.ForceColor {
+		color.NoColor = !c.ForceColor
+	}
+}
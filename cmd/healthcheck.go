@@ -0,0 +1,92 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	healthcheckAddr  string
+	healthcheckReady bool
+)
+
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Checks the health of a running instance",
+	Long: `Queries /healthz (or /readyz with --ready) of a running instance
+and exits with 0 if it is healthy and with 1 otherwise. Meant for
+Docker and Kubernetes, the image has no curl.
+
+The address is the health-listen of the configuration unless --addr
+is given.`,
+	Example: `  HEALTHCHECK CMD ["/gluetun-sync", "healthcheck"]`,
+	Args:    cobra.NoArgs,
+	// A broken configuration shouldn't make the check fail, the running
+	// instance might be using an older one
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		addr := healthcheckAddr
+		if addr == "" {
+			v := newViper(cfgFile)
+			readConfig(v)
+			addr = v.GetString("health-listen")
+		}
+
+		if err := healthcheck(addr, healthcheckReady); err != nil {
+			slog.Error("unhealthy", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func healthcheck(addr string, ready bool) error {
+	if addr == "" {
+		return fmt.Errorf("the health server is disabled, set health-listen")
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	path := "/healthz"
+	if ready {
+		path = "/readyz"
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body := struct {
+			Reasons []string `json:"reasons"`
+		}{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("%s answered %d %s", path, resp.StatusCode, strings.Join(body.Reasons, "; "))
+	}
+
+	return nil
+}
+
+func init() {
+	healthcheckCmd.Flags().StringVar(&healthcheckAddr, "addr", "", "address of the health server (default is health-listen of the configuration)")
+	healthcheckCmd.Flags().BoolVar(&healthcheckReady, "ready", false, "check /readyz instead of /healthz")
+
+	rootCmd.AddCommand(healthcheckCmd)
+}
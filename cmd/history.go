@@ -0,0 +1,141 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/afiestas/gluetun-sync/lib"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyFile   string
+	historyGroups []string
+	historySince  string
+	historyUntil  string
+	historyFailed bool
+	historyLimit  int
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Shows the recorded port changes and sync results",
+	Long: `Prints the port changes and the result of every group recorded in
+the history-file of the configuration, oldest first.
+
+--since and --until take a duration ago (24h), a date (2006-01-02) or
+an RFC 3339 time.`,
+	Example: `  gluetun-sync history --since 24h
+  gluetun-sync history --group qbittorrent --failed
+  gluetun-sync history -n 20 -o json`,
+	Args: cobra.NoArgs,
+	// The history is most useful when something is broken, a configuration
+	// that doesn't validate shouldn't prevent reading it
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		file := historyFile
+		if file == "" {
+			v := newViper(cfgFile)
+			readConfig(v)
+			file = v.GetString("history-file")
+		}
+		if file == "" {
+			slog.Error("the history is disabled, set history-file")
+			os.Exit(1)
+		}
+
+		filter, err := historyFilter()
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+
+		entries, err := lib.ReadHistory(file, filter)
+		if err != nil {
+			slog.Error("couldn't read the history", "file", file, "error", err)
+			os.Exit(1)
+		}
+		if historyLimit > 0 && len(entries) > historyLimit {
+			entries = entries[len(entries)-historyLimit:]
+		}
+
+		printHistory(entries)
+	},
+}
+
+func historyFilter() (lib.HistoryFilter, error) {
+	now := time.Now()
+	filter := lib.HistoryFilter{Groups: historyGroups, FailedOnly: historyFailed}
+
+	var err error
+	if historySince != "" {
+		if filter.Since, err = lib.ParseHistoryTime(historySince, now); err != nil {
+			return filter, err
+		}
+	}
+	if historyUntil != "" {
+		if filter.Until, err = lib.ParseHistoryTime(historyUntil, now); err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+// printHistory prints the entries as a table or, with --output json, as JSON
+// lines
+func printHistory(entries []lib.HistoryEntry) {
+	if outputFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		for _, entry := range entries {
+			encoder.Encode(entry)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tGROUP\tPORT\tRESULT\tDETAILS")
+	for _, entry := range entries {
+		when := entry.Time.Local().Format(time.DateTime)
+		if entry.Type == lib.HistoryPort {
+			details := ""
+			if entry.PreviousPort != 0 {
+				details = fmt.Sprintf("was %d", entry.PreviousPort)
+			}
+			fmt.Fprintf(w, "%s\t-\t%d\tport changed\t%s\n", when, entry.Port, details)
+			continue
+		}
+
+		result, details := "synced", ""
+		if !entry.Success {
+			result = "failed"
+			details = entry.Error
+			if entry.FailedStep > 0 {
+				details = fmt.Sprintf("step %d: %s", entry.FailedStep, entry.Error)
+			}
+		}
+		if entry.Attempt > 1 {
+			result = fmt.Sprintf("%s (attempt %d)", result, entry.Attempt)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", when, entry.Group, entry.Port, result, details)
+	}
+	w.Flush()
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historyFile, "file", "", "history file to read (default is history-file of the configuration)")
+	historyCmd.Flags().StringArrayVarP(&historyGroups, "group", "g", nil, "only show this group, can be repeated")
+	historyCmd.Flags().StringVar(&historySince, "since", "", "only show what happened after this time")
+	historyCmd.Flags().StringVar(&historyUntil, "until", "", "only show what happened before this time")
+	historyCmd.Flags().BoolVar(&historyFailed, "failed", false, "only show the failed groups")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 0, "only show the last n entries")
+	historyCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format, text or json")
+
+	rootCmd.AddCommand(historyCmd)
+}
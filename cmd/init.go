@@ -0,0 +1,312 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/afiestas/gluetun-sync/lib"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	initOutput         string
+	initPortFile       string
+	initForce          bool
+	initNonInteractive bool
+	initIntegrations   []string
+	initSecrets        string
+	// initValues holds the integration flags by integration and field name
+	initValues = map[string]map[string]*string{}
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Creates a configuration file",
+	Long: `Asks where gluetun writes the forwarded port and which services
+have to be kept in sync, then writes a validated configuration file.
+
+Every question can be answered with flags, with --non-interactive
+nothing is asked and the integrations given with --integration or
+with any of their flags are configured.
+
+Passwords and webhook urls are written in the configuration unless
+--secrets env is used, then the configuration refers to environment
+variables like ${GLUETUN_SYNC_QBITTORRENT_PASSWORD} which are also
+where the secrets are taken from when they aren't given.`,
+	Example: `  gluetun-sync init
+  gluetun-sync init --non-interactive --output /etc/gluetun-sync/config.yaml \
+    --qbittorrent-url http://localhost:8080 --qbittorrent-password secret
+  GLUETUN_SYNC_QBITTORRENT_PASSWORD=secret gluetun-sync init --secrets env --integration qbittorrent`,
+	Args:             cobra.NoArgs,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		if initSecrets != "inline" && initSecrets != "env" {
+			slog.Error("--secrets must be inline or env", "secrets", initSecrets)
+			os.Exit(1)
+		}
+
+		var p *prompter
+		if !initNonInteractive {
+			p = &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout, fd: int(os.Stdin.Fd())}
+		}
+
+		if err := initConfigFile(cmd, p); err != nil {
+			slog.Error("couldn't create the configuration", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// prompter asks questions on the terminal, a nil prompter answers every
+// question with its default
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	fd  int
+}
+
+func (p *prompter) ask(question string, defaultValue string) (string, error) {
+	if p == nil {
+		return defaultValue, nil
+	}
+
+	if defaultValue != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+
+	answer, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return "", fmt.Errorf("couldn't read answer %w", err)
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+// askSecret doesn't echo the answer when reading from a terminal nor shows
+// the default
+func (p *prompter) askSecret(question string, defaultValue string) (string, error) {
+	if p == nil {
+		return defaultValue, nil
+	}
+	if defaultValue != "" {
+		question += " (empty to keep the given one)"
+	}
+
+	var answer string
+	if term.IsTerminal(p.fd) {
+		fmt.Fprintf(p.out, "%s: ", question)
+		secret, err := term.ReadPassword(p.fd)
+		fmt.Fprintln(p.out)
+		if err != nil {
+			return "", fmt.Errorf("couldn't read answer %w", err)
+		}
+		answer = string(secret)
+	} else {
+		var err error
+		if answer, err = p.ask(question, ""); err != nil {
+			return "", err
+		}
+	}
+
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+func (p *prompter) confirm(question string, defaultValue bool) (bool, error) {
+	options := "y/N"
+	if defaultValue {
+		options = "Y/n"
+	}
+
+	for {
+		answer, err := p.ask(fmt.Sprintf("%s (%s)", question, options), "")
+		if err != nil || answer == "" {
+			return defaultValue, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+func initConfigFile(cmd *cobra.Command, p *prompter) error {
+	if _, err := os.Stat(initOutput); err == nil && !initForce {
+		overwrite := false
+		if p != nil {
+			overwrite, _ = p.confirm(fmt.Sprintf("%s already exists, overwrite it?", initOutput), false)
+		}
+		if !overwrite {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", initOutput)
+		}
+	}
+
+	portFile, err := p.ask("File where gluetun writes the forwarded port", initPortFile)
+	if err != nil {
+		return err
+	}
+
+	// groups have the secrets to be validated, output is what is written
+	groups := map[string]lib.RequestGroup{}
+	output := map[string]lib.RequestGroup{}
+	variables := []string{}
+	for _, integration := range lib.Integrations {
+		enabled := integrationSelected(cmd, integration)
+		if p != nil {
+			enabled, err = p.confirm(fmt.Sprintf("Sync %s?", integration.Title), enabled)
+			if err != nil {
+				return err
+			}
+		}
+		if !enabled {
+			continue
+		}
+
+		values, err := integrationValues(p, integration)
+		if err != nil {
+			return err
+		}
+		groups[integration.Name] = integration.Build(values)
+		output[integration.Name] = groups[integration.Name]
+		if initSecrets == "env" {
+			refs, names := secretReferences(integration, values)
+			output[integration.Name] = integration.Build(refs)
+			variables = append(variables, names...)
+		}
+	}
+	if len(groups) == 0 {
+		return errors.New("no integration was selected, there is nothing to sync")
+	}
+
+	c := lib.Configuration{PortFile: portFile, Requests: groups}
+	if errs := lib.ValidateConfig(c); len(errs) > 0 {
+		for _, e := range errs {
+			slog.Error("invalid configuration", "error", e)
+		}
+		return errors.New("the configuration is not valid")
+	}
+
+	c.Requests = output
+	settings := lib.Settings(c).(map[string]any)
+	content, err := lib.EncodeConfig(settings, initOutput)
+	if err != nil {
+		return fmt.Errorf("couldn't encode the configuration %w", err)
+	}
+
+	// The configuration might contain credentials
+	if err := os.WriteFile(initOutput, content, 0600); err != nil {
+		return fmt.Errorf("couldn't write %s %w", initOutput, err)
+	}
+	slog.Info("configuration written", "file", initOutput)
+	if len(variables) > 0 {
+		sort.Strings(variables)
+		slog.Info("set the secrets in the environment", "variables", strings.Join(variables, ","))
+	}
+
+	return nil
+}
+
+func integrationSelected(cmd *cobra.Command, integration lib.Integration) bool {
+	for _, name := range initIntegrations {
+		if name == integration.Name {
+			return true
+		}
+	}
+	for _, field := range integration.Fields {
+		if cmd.Flags().Changed(integrationFlag(integration, field)) {
+			return true
+		}
+	}
+	return false
+}
+
+func integrationValues(p *prompter, integration lib.Integration) (map[string]string, error) {
+	values := map[string]string{}
+	for _, field := range integration.Fields {
+		value := *initValues[integration.Name][field.Name]
+		var err error
+		if field.Secret {
+			if value == "" && initSecrets == "env" {
+				value = os.Getenv(secretVariable(integration, field))
+			}
+			value, err = p.askSecret("  "+field.Prompt, value)
+		} else {
+			value, err = p.ask("  "+field.Prompt, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if field.Required && value == "" {
+			return nil, fmt.Errorf("%s is required, use --%s", field.Prompt, integrationFlag(integration, field))
+		}
+		values[field.Name] = value
+	}
+
+	return values, nil
+}
+
+// secretReferences replaces the secrets in values with references to their
+// environment variables and returns the names of the variables
+func secretReferences(integration lib.Integration, values map[string]string) (map[string]string, []string) {
+	refs := map[string]string{}
+	names := []string{}
+	for _, field := range integration.Fields {
+		refs[field.Name] = values[field.Name]
+		if field.Secret && values[field.Name] != "" {
+			name := secretVariable(integration, field)
+			refs[field.Name] = "${" + name + "}"
+			names = append(names, name)
+		}
+	}
+
+	return refs, names
+}
+
+// secretVariable names the environment variable of a secret field, e.g.
+// GLUETUN_SYNC_SLACK_WEBHOOK_URL
+func secretVariable(integration lib.Integration, field lib.IntegrationField) string {
+	name := "GLUETUN_SYNC_" + integration.Name + "_" + field.Name
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func integrationFlag(integration lib.Integration, field lib.IntegrationField) string {
+	return integration.Name + "-" + field.Name
+}
+
+func init() {
+	initCmd.Flags().StringVarP(&initOutput, "output", "o", "config.yaml", "configuration file to write, the format is taken from the extension")
+	initCmd.Flags().StringVar(&initPortFile, "port-file", lib.DefaultPortFile, "file where gluetun writes the forwarded port")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "overwrite the configuration file if it exists")
+	initCmd.Flags().BoolVar(&initNonInteractive, "non-interactive", false, "don't ask anything, use the flags and defaults")
+	initCmd.Flags().StringVar(&initSecrets, "secrets", "inline", "where the secrets are kept, inline in the configuration or env to refer to environment variables")
+
+	names := []string{}
+	for _, integration := range lib.Integrations {
+		names = append(names, integration.Name)
+		initValues[integration.Name] = map[string]*string{}
+		for _, field := range integration.Fields {
+			usage := fmt.Sprintf("%s %s", integration.Title, strings.ToLower(field.Prompt))
+			initValues[integration.Name][field.Name] = initCmd.Flags().String(integrationFlag(integration, field), field.Default, usage)
+		}
+	}
+	initCmd.Flags().StringSliceVar(&initIntegrations, "integration", nil, "integration to configure, one of "+strings.Join(names, ", "))
+
+	rootCmd.AddCommand(initCmd)
+}
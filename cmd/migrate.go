@@ -0,0 +1,126 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/afiestas/gluetun-sync/lib"
+	"github.com/spf13/cobra"
+)
+
+var migrateStdout bool
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [file...]",
+	Short: "Rewrites configuration files in the canonical format",
+	Long: `Rewrites configuration files that use the legacy list of requests
+into the canonical map of groups. A backup of every rewritten file
+is kept with the .bak extension.
+
+Without arguments the configuration file and the drop-ins of the
+config directory are migrated. Files with anything that couldn't be
+converted are left untouched and the command exits with 1.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		files := args
+		if len(files) == 0 {
+			var err error
+			files, err = configFiles()
+			if err != nil {
+				slog.Error("couldn't find the configuration files", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		failed := false
+		for _, file := range files {
+			if err := migrate(file); err != nil {
+				for _, e := range splitErrors(err) {
+					slog.Error("couldn't migrate", "file", file, "error", e)
+				}
+				failed = true
+			}
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// configFiles returns the configuration file and the drop-ins
+func configFiles() ([]string, error) {
+	v := newViper(cfgFile)
+	files := []string{}
+	err := v.ReadInConfig()
+	if err == nil {
+		files = append(files, v.ConfigFileUsed())
+	}
+
+	dir := v.GetString("config-dir")
+	if dir == "" {
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find config file %w", err)
+		}
+		return files, nil
+	}
+
+	dropIns, err := lib.ConfigDirFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config dir %w", err)
+	}
+	return append(files, dropIns...), nil
+}
+
+func migrate(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	migrated, changed, err := lib.MigrateConfig(content, file)
+	if err != nil {
+		return err
+	}
+
+	if migrateStdout {
+		fmt.Print(string(migrated))
+		return nil
+	}
+
+	if !changed {
+		slog.Info("nothing to migrate", "file", file)
+		return nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file+".bak", content, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("couldn't write backup %w", err)
+	}
+	if err := os.WriteFile(file, migrated, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	slog.Info("migrated", "file", file, "backup", file+".bak")
+	return nil
+}
+
+// splitErrors returns the errors joined in err so each one is logged apart
+func splitErrors(err error) []error {
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		return []error{err}
+	}
+	return joined.Unwrap()
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateStdout, "stdout", false, "Prints the migrated configuration instead of rewriting the files")
+	rootCmd.AddCommand(migrateCmd)
+}
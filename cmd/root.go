@@ -2,22 +2,33 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"time"
 
 	"github.com/afiestas/gluetun-sync/lib"
-	"github.com/fatih/color"
-	"github.com/fsnotify/fsnotify"
-	"github.com/go-playground/validator/v10"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+)
+
+// Exit codes so cron jobs and init containers can tell what went wrong
+const (
+	exitConfigError    = 2
+	exitPortError      = 3
+	exitPartialFailure = 4
+	exitFailure        = 5
 )
 
 var (
-	cfgFile   string
-	config    lib.Configuration
-	requester lib.Requester = lib.NewRequester()
+	requester    lib.Requester = lib.NewRequester()
+	metrics                    = lib.NewMetrics()
+	state                      = lib.NewState()
+	history                    = lib.NewHistory()
+	outputFormat string
+	logLevel     string
+	logFormat    string
 )
 
 var rootCmd = &cobra.Command{
@@ -29,186 +40,165 @@ applications in sync.
 
 For example you can change the listening port (or the mapping) for
 any self-hosted software such as video game servers, nextcloud, etc.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		if config.Once {
-			once()
-			return
-		}
-
-		watchAndSync()
-	},
 }
 
-func updatePort(port uint16) error {
-	updateCh, quitCh := lib.PrintRequester()
-	err := requester.SendRequests(port, config.Requests, updateCh)
-	<-quitCh
-
-	return err
-}
-
-func watchAndSync() {
-	lib.Info(fmt.Sprintf("Monitoring: %s ", config.PortFile))
-	portCh, quit, err := lib.PortChangeNotifier(config.PortFile, 1000)
-	if err != nil {
-		fmt.Println("❌")
-		lib.PrintError(fmt.Errorf("watchg file err %w", err))
+func runRoot(cmd *cobra.Command, args []string) {
+	config := currentConfig()
+	if config.DryRun {
+		dryRun()
 		return
 	}
 
-	fmt.Println("✅")
-	var timer *time.Timer
-	for {
-		select {
-		case port, ok := <-portCh:
-			if !ok {
-				continue
-			}
-			if timer != nil {
-				timer.Stop()
-				timer = nil
-			}
-			fmt.Printf("Detected port %d\n", port)
-			err := updatePort(port)
-			if err != nil {
-				lib.Info("Retrying every 10 seconds\n")
-				timer = time.AfterFunc(time.Second*2, func() {
-					err := updatePort(port)
-					if err != nil {
-						fmt.Println("Error happened, reseting timer")
-						timer.Reset(time.Second * 2)
-						return
-					}
-					timer.Stop()
-					timer = nil
-				})
-			}
-		case <-quit:
-			return
-		}
+	if config.Once {
+		once()
+		return
 	}
+
+	serve(config)
+	watchAndSync()
+}
+
+// syncGroups sends the requests of groups logging the progress
+func syncGroups(run lib.SyncRun, groups map[string]lib.RequestGroup) (lib.SyncResult, error) {
+	results := &lib.ResultReporter{}
+	reporter := lib.MultiReporter{lib.NewLogReporter(slog.Default()), results, metrics, state, history}
+	err := requester.Sync(run, groups, reporter)
+
+	return results.Result(), err
 }
 
 func once() {
-	lib.Info("Synchronizing port once")
+	config := currentConfig()
+	slog.Info("synchronizing port once")
+	os.Exit(syncOnce(config.Requests))
+}
+
+// configuredPort returns the port given with --port or the one in the port
+// file otherwise
+func configuredPort() (uint16, error) {
+	config := currentConfig()
+	if config.Port != 0 {
+		return config.Port, nil
+	}
+
 	port, err := lib.GetPortFromFile(config.PortFile)
-	fmt.Printf("Detected port %d\n", port)
 	if err != nil {
-		lib.PrintError(fmt.Errorf("error while reading port from file %w", err))
-		return
+		return 0, fmt.Errorf("error while reading port from file %w", err)
 	}
-	updatePort(port)
+	slog.Info("detected port", "port", port, "file", config.PortFile)
+
+	return port, nil
 }
 
-func Execute() {
-	err := rootCmd.Execute()
+// syncOnce synchronizes groups a single time and returns the exit code
+func syncOnce(groups map[string]lib.RequestGroup) int {
+	port, err := configuredPort()
 	if err != nil {
-		os.Exit(1)
+		slog.Error("couldn't read the port", "error", err)
+		printResult(lib.SyncResult{Error: lib.Redact(err.Error()), Groups: []lib.GroupResult{}})
+		return exitPortError
 	}
-}
 
-func init() {
-	cobra.OnInitialize(initConfig)
+	result, _ := syncGroups(lib.NewSyncRun(port), groups)
+	printResult(result)
 
-	pFlags := rootCmd.PersistentFlags()
-	pFlags.StringVar(&cfgFile, "config", "", "config file (default is /etc/gluetun-sync/config.toml).")
-	pFlags.String("port-file", "/tmp/portfile", "The path to where the gluetun port file is")
-	pFlags.BoolP("force-color", "f", false, "Forces color output")
-	pFlags.BoolP("once", "1", false, "Tries to synchronize just once")
-
-	viper.BindPFlags(pFlags)
+	switch result.Failed() {
+	case 0:
+		return 0
+	case len(result.Groups):
+		return exitFailure
+	}
+	return exitPartialFailure
 }
 
-func setupViper() {
-	if cfgFile != "" {
-		viper.SetConfigFile(cfgFile)
-	} else {
-		viper.SetConfigName("config")
-		viper.AddConfigPath("/etc/gluetun-sync")
-		viper.AddConfigPath(".")
+func printResult(result lib.SyncResult) {
+	if outputFormat != "json" {
+		return
 	}
-	viper.SetEnvPrefix("GLUESYNC")
-	viper.AutomaticEnv()
 
-	if err := viper.ReadInConfig(); err != nil {
-		lib.PrintStepError(fmt.Errorf("couldn't parse config file %w", err))
-		os.Exit(1)
-	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
 }
 
-func unmarshalAndValidate() (lib.Configuration, error) {
-	c := lib.Configuration{}
-	err := viper.UnmarshalExact(&c)
-	if err != nil {
-		return c, err
+// setupLogging configures the logger, with --output json stdout is kept for
+// the results and the logs go to stderr instead
+func setupLogging(format string, level string) error {
+	var w io.Writer = os.Stdout
+	if outputFormat == "json" {
+		w = os.Stderr
 	}
+	return lib.SetupLogger(w, format, level)
+}
 
-	validate := validator.New(validator.WithRequiredStructEnabled())
-	err = validate.Struct(c)
+func initLogging() {
+	err := setupLogging(logFormat, logLevel)
+	if err == nil && outputFormat != "text" && outputFormat != "json" {
+		err = fmt.Errorf("unknown output format %s, use text or json", outputFormat)
+	}
 	if err != nil {
-		return c, err
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
-
-	return c, nil
 }
 
-func printConfigError(err error) {
-	if err == nil {
-		return
+func dryRun() {
+	config := currentConfig()
+	port, err := configuredPort()
+	if err != nil {
+		slog.Error("couldn't read the port", "error", err)
+		os.Exit(exitPortError)
 	}
 
-	if err != nil {
-		if _, ok := err.(*validator.InvalidValidationError); ok {
-			lib.PrintStepError(err)
+	slog.Info("rendering requests", "port", port)
+	rendered, err := lib.RenderRequests(port, config.Requests)
+	for k, request := range rendered {
+		if k == 0 || rendered[k-1].Service != request.Service {
+			lib.PrintService(request.Service)
 		}
+		lib.PrintRendered(request)
+	}
 
-		errs := err.(validator.ValidationErrors)
-		for _, e := range errs {
-			lib.PrintStepError(e)
+	if err != nil {
+		for _, e := range err.(*lib.RequesterError).Errors {
+			slog.Error("couldn't render request", "error", e)
 		}
+		os.Exit(exitConfigError)
 	}
 }
 
-func initConfig() {
-	setupViper()
-	lib.Info(fmt.Sprintf("Configuration: %s ", viper.ConfigFileUsed()))
-
-	var err error
-	config, err = unmarshalAndValidate()
+func Execute() {
+	err := rootCmd.Execute()
 	if err != nil {
-		fmt.Println("❌")
-		printConfigError(err)
 		os.Exit(1)
 	}
-	fmt.Println("✅")
-
-	var timer *time.Timer
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		if timer != nil {
-			timer.Reset(time.Second * 1)
-			return
-		}
+}
 
-		timer = time.AfterFunc(time.Second*1, func() {
-			timer.Stop()
-			timer = nil
-
-			lib.Info(fmt.Sprintf("Config file changed: %s ", e.Name))
-			newConfig, err := unmarshalAndValidate()
-			if err != nil {
-				fmt.Println("❌")
-				fmt.Println("can't parse new configuration")
-				printConfigError(err)
-				return
-			}
-			fmt.Println("✅")
-			lib.Info("Updating configuration\n")
-			config = newConfig
-		})
-	})
-	viper.WatchConfig()
-
-	if config.ForceColor {
-		color.NoColor = !config.ForceColor
+func init() {
+	pFlags := rootCmd.PersistentFlags()
+	pFlags.StringVar(&cfgFile, "config", "", "config file (default is /etc/gluetun-sync/config.toml).")
+	pFlags.String("config-dir", "", "directory with drop-in config files (*.yaml, *.toml, *.json) merged into the config")
+	pFlags.String("port-file", "/tmp/portfile", "The path to where the gluetun port file is")
+	pFlags.BoolP("force-color", "f", false, "Forces color output")
+	pFlags.BoolP("once", "1", false, "Tries to synchronize just once")
+	pFlags.Bool("dry-run", false, "Renders every request without sending it")
+	pFlags.Uint16("port", 0, "Port to use with --once, --dry-run and sync (default is the one in the port file)")
+	pFlags.String("metrics-listen", "", "Address to serve Prometheus metrics on /metrics, e.g. :9090")
+	pFlags.String("health-listen", defaultHealthListen, "Address to serve /healthz and /readyz on, empty to disable")
+	pFlags.String("api-listen", "", "Address to serve the control api on, requires api-token or api-token-file")
+	pFlags.Duration("ready-threshold", 5*time.Minute, "Time groups have to synchronize a new port before /readyz fails")
+	pFlags.String("history-file", "", "JSON lines file to record port changes and sync results in, empty to disable")
+	pFlags.Duration("history-max-age", 30*24*time.Hour, "History older than this is dropped, 0 to keep it forever")
+	pFlags.Int("history-max-entries", 10000, "Maximum number of history entries, 0 for no limit")
+	pFlags.StringVar(&logLevel, "log-level", "info", "Log level, one of debug, info, warn or error")
+	pFlags.StringVar(&logFormat, "log-format", lib.LogFormatConsole, "Log format, one of console, text or json")
+	rootCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format of --once results, text or json")
+
+	// Set here, initConfig and the config reloads refer back to rootCmd for
+	// its flags
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		initConfig()
 	}
+	rootCmd.Run = runRoot
+	cobra.OnInitialize(initLogging)
 }
@@ -0,0 +1,56 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/afiestas/gluetun-sync/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Prints the JSON Schema of the configuration file",
+	Long: `Generates the JSON Schema of the configuration file, it can be
+used by editors to autocomplete and validate the configuration or
+in CI to validate configurations.`,
+	Args:             cobra.NoArgs,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		defaults := map[string]any{}
+		rootCmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+			if flag.DefValue != "" {
+				defaults[flag.Name] = flagDefault(flag)
+			}
+		})
+
+		schema, err := json.MarshalIndent(lib.GenerateSchema(defaults), "", "  ")
+		if err != nil {
+			slog.Error("couldn't generate the schema", "error", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(schema))
+	},
+}
+
+func flagDefault(flag *pflag.Flag) any {
+	switch flag.Value.Type() {
+	case "bool":
+		return flag.DefValue == "true"
+	case "int", "uint", "uint16", "uint32", "int64":
+		n, err := strconv.Atoi(flag.DefValue)
+		if err == nil {
+			return n
+		}
+	}
+	return flag.DefValue
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
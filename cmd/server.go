@@ -0,0 +1,52 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/afiestas/gluetun-sync/lib"
+)
+
+const defaultHealthListen = "127.0.0.1:9999"
+
+// serve starts the http servers in the background, endpoints configured with
+// the same address share a server. Addresses are only read at start up,
+// changing them requires a restart.
+func serve(c lib.Configuration) {
+	muxes := map[string]*http.ServeMux{}
+	handle := func(addr string, path string, handler http.Handler) {
+		if addr == "" {
+			return
+		}
+		if _, ok := muxes[addr]; !ok {
+			muxes[addr] = http.NewServeMux()
+		}
+		muxes[addr].Handle(path, handler)
+	}
+
+	handle(c.MetricsListen, "/metrics", metrics)
+	handle(c.HealthListen, "/healthz", state.HealthHandler())
+	handle(c.HealthListen, "/readyz", state.ReadyHandler())
+	handleAPI(func(path string, handler http.Handler) {
+		handle(c.ApiListen, path, handler)
+	})
+
+	addrs := make([]string, 0, len(muxes))
+	for addr := range muxes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		server := &http.Server{Addr: addr, Handler: muxes[addr], ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			slog.Info("serving http", "addr", server.Addr)
+			if err := server.ListenAndServe(); err != nil {
+				slog.Error("couldn't serve http", "addr", server.Addr, "error", err)
+			}
+		}()
+	}
+}
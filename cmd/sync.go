@@ -0,0 +1,44 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/afiestas/gluetun-sync/lib"
+	"github.com/spf13/cobra"
+)
+
+var syncGroupNames []string
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Synchronizes some groups once",
+	Long: `Sends the requests of the given groups once and exits, the rest
+of the groups are left alone. Useful to retry a service that
+failed without notifying every other one again.
+
+The port of the port file is used unless --port is given.`,
+	Example: `  gluetun-sync sync --group qbittorrent
+  gluetun-sync sync --group qbittorrent --group slack --port 1337`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		groups, err := lib.SelectGroups(currentConfig().Requests, syncGroupNames)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+
+		slog.Info("synchronizing groups", "groups", strings.Join(syncGroupNames, ","))
+		os.Exit(syncOnce(groups))
+	},
+}
+
+func init() {
+	syncCmd.Flags().StringArrayVarP(&syncGroupNames, "group", "g", nil, "group to synchronize, can be repeated")
+	syncCmd.MarkFlagRequired("group")
+	syncCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format of the results, text or json")
+
+	rootCmd.AddCommand(syncCmd)
+}
@@ -0,0 +1,69 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/afiestas/gluetun-sync/lib"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Validates a configuration file",
+	Long: `Checks the configuration schema, that every template compiles
+and that there are no unknown keys. Exits with 0 if the configuration
+is valid and with 1 otherwise so it can be used in CI. Environment
+variables that aren't set are replaced by placeholders.`,
+	Args: cobra.MaximumNArgs(1),
+	// The configuration is loaded by the command itself, it must not exit
+	// on errors like the root command does
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		file := cfgFile
+		if len(args) == 1 {
+			file = args[0]
+		}
+
+		if !validate(file) {
+			os.Exit(1)
+		}
+	},
+}
+
+func validate(file string) bool {
+	v := newViper(file)
+	sources, err := readConfig(v)
+	name := configName(v, sources)
+	if err != nil {
+		slog.Error("couldn't parse config file", "config", name, "error", err)
+		return false
+	}
+
+	// The environment of the deployment isn't the one validating
+	unset := map[string]struct{}{}
+	_, errs := unmarshalAndValidate(v, lib.ValidationDecodeHook(func(name string) { unset[name] = struct{}{} }))
+	if len(unset) > 0 {
+		names := make([]string, 0, len(unset))
+		for name := range unset {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		slog.Warn("environment variables are not set, checked with placeholders", "variables", strings.Join(names, ","))
+	}
+	if len(errs) > 0 {
+		printConfigErrors(sources, errs)
+		return false
+	}
+
+	slog.Info("configuration is valid", "config", name)
+	printLegacyWarning(sources)
+	return true
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
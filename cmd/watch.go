@@ -0,0 +1,171 @@
+/* SPDX-License-Identifier: MIT */
+package cmd
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/afiestas/gluetun-sync/lib"
+)
+
+const retryInterval = time.Second * 2
+
+func watchPortFile(portFile string) (chan uint16, chan struct{}, error) {
+	portCh, quit, err := lib.PortChangeNotifier(portFile, 1000)
+	if err != nil {
+		slog.Error("couldn't monitor the port file", "file", portFile, "error", err)
+		return nil, nil, err
+	}
+
+	slog.Info("monitoring port file", "file", portFile)
+	state.SetWatching(portFile, true)
+	return portCh, quit, nil
+}
+
+// watchAndSync is the only place where requests are sent while watching, port
+// changes, retries, periodic resyncs, configuration reloads, api calls and
+// signals are all serialized here. What is synchronized is decided by
+// lib.Watcher, the loop only owns the channels and timers.
+func watchAndSync() {
+	portCh, quit, err := watchPortFile(currentConfig().PortFile)
+	if err != nil {
+		os.Exit(exitPortError)
+	}
+
+	watcher := lib.NewWatcher(currentConfig(), func(run lib.SyncRun, groups map[string]lib.RequestGroup) lib.SyncResult {
+		result, _ := syncGroups(run, groups)
+		return result
+	}, func(port, previous uint16) {
+		history.RecordPort(port, previous)
+		metrics.ObservePort(port)
+		state.ObservePort(port)
+	})
+
+	// switchPortFile watches file instead of the current port file
+	switchPortFile := func(file string) error {
+		newPortCh, newQuit, err := watchPortFile(file)
+		if err != nil {
+			return err
+		}
+		close(quit)
+		portCh, quit = newPortCh, newQuit
+		return nil
+	}
+
+	var retryCh <-chan time.Time
+	var resyncCh <-chan time.Time
+	// plan wakes the loop up for the retries of the groups that failed and
+	// for the earliest resync
+	plan := func() {
+		if pending := watcher.Pending(); len(pending) > 0 && retryCh == nil {
+			slog.Warn("retrying failed groups", "in", retryInterval, "groups", len(pending))
+			retryCh = time.After(retryInterval)
+		}
+		resyncCh = nil
+		if next, ok := watcher.NextResync(); ok {
+			resyncCh = time.After(time.Until(next))
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1)
+
+	heartbeat := time.NewTicker(lib.HeartbeatInterval)
+	defer heartbeat.Stop()
+	state.Heartbeat()
+
+	for {
+		select {
+		case <-heartbeat.C:
+			state.Heartbeat()
+			continue
+		case newPort, ok := <-portCh:
+			if !ok {
+				slog.Error("stopped monitoring the port file", "file", watcher.Config().PortFile)
+				state.SetWatching(watcher.Config().PortFile, false)
+				portCh = nil
+				continue
+			}
+			if newPort == watcher.Port() {
+				continue
+			}
+			slog.Info("detected port", "port", newPort, "file", watcher.Config().PortFile)
+			watcher.SetPort(newPort)
+		case c := <-syncCommands:
+			c.reply <- command(watcher, c)
+		case <-retryCh:
+			retryCh = nil
+			watcher.Retry()
+		case <-configReloaded:
+			changed := watcher.Reload(currentConfig(), switchPortFile)
+			if watcher.Port() != 0 && len(changed) > 0 {
+				slog.Info("synchronizing changed groups", "groups", len(changed))
+				watcher.Sync(changed)
+			}
+		case <-resyncCh:
+			watcher.Resync(time.Now())
+		case sig := <-signals:
+			switch sig {
+			case syscall.SIGHUP:
+				slog.Info("reloading configuration and synchronizing every group", "signal", sig)
+				reloadConfig()
+				// Applied right away, the groups are synchronized below
+				select {
+				case <-configReloaded:
+				default:
+				}
+				watcher.Reload(currentConfig(), switchPortFile)
+				if err := watcher.Sync(watcher.Config().Requests); err != nil {
+					slog.Warn("nothing to synchronize", "error", err)
+				}
+			case syscall.SIGUSR1:
+				dumpState(watcher.LastRun(), watcher.Pending())
+			}
+		}
+		plan()
+	}
+}
+
+// command runs a sync asked through the control api
+func command(watcher *lib.Watcher, c syncCommand) syncReply {
+	result, err := watcher.Command(c.port, c.groups)
+	switch {
+	case errors.Is(err, lib.ErrGroupsWithPort):
+		return syncReply{code: http.StatusBadRequest, err: err}
+	case errors.Is(err, lib.ErrUnknownGroup):
+		return syncReply{code: http.StatusNotFound, err: err}
+	case errors.Is(err, lib.ErrPortUnknown):
+		return syncReply{code: http.StatusConflict, err: err}
+	}
+	return syncReply{result: result}
+}
+
+// dumpState logs what the watcher knows, the outcome of the last sync of
+// every group and the ones waiting to be retried
+func dumpState(run lib.SyncRun, pending []string) {
+	snapshot := state.Snapshot()
+	slog.Info("state", "port", snapshot.Port, "file", snapshot.PortFile, "watching", snapshot.Watching,
+		"run", run.ID, "attempt", run.Attempt, "pending-retries", strings.Join(pending, ","))
+	for _, group := range snapshot.Groups {
+		attrs := []any{"group", group.Name, "status", group.Status}
+		if group.Port != 0 {
+			attrs = append(attrs, "port", group.Port)
+		}
+		if !group.LastSync.IsZero() {
+			attrs = append(attrs, "last-sync", group.LastSync.Format(time.RFC3339))
+		}
+		if !group.LastSuccess.IsZero() {
+			attrs = append(attrs, "last-success", group.LastSuccess.Format(time.RFC3339))
+		}
+		if group.Error != "" {
+			attrs = append(attrs, "error", group.Error)
+		}
+		slog.Info("group state", attrs...)
+	}
+}
@@ -0,0 +1,83 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// APIError is the body of the control api errors
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// secretSettings are the keys whose values are always masked by
+// RedactedSettings, even when they are too short to be registered as secrets
+var secretSettings = map[string]struct{}{
+	"password":      {},
+	"token":         {},
+	"client-secret": {},
+	"api-token":     {},
+}
+
+// RequireToken only lets requests with the bearer token returned by token
+// through, it is called for every request so the token can be rotated
+func RequireToken(token func() (string, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected, err := token()
+		if err != nil || expected == "" {
+			slog.Error("couldn't read the api token", "error", err)
+			WriteJSON(w, http.StatusInternalServerError, APIError{Error: "the api token is not available"})
+			return
+		}
+
+		scheme, given, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gluetun-sync"`)
+			WriteJSON(w, http.StatusUnauthorized, APIError{Error: "missing or invalid bearer token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllowMethod answers 405 unless r uses method
+func AllowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	WriteJSON(w, http.StatusMethodNotAllowed, APIError{Error: "use " + method})
+	return false
+}
+
+// RedactedSettings returns the settings of c with the secrets, url passwords
+// and sensitive params masked
+func RedactedSettings(c Configuration) any {
+	return redactSettings(Settings(c))
+}
+
+func redactSettings(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, setting := range v {
+			if _, ok := secretSettings[key]; ok {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = redactSettings(setting)
+		}
+	case []any:
+		for i, setting := range v {
+			v[i] = redactSettings(setting)
+		}
+	case string:
+		return Redact(v)
+	}
+
+	return value
+}
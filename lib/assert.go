@@ -0,0 +1,107 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Failed assertions quote this much of the body around the offending part
+const snippetSize = 80
+
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+// check returns why the response doesn't follow the assertion, if it doesn't
+func (a Assertion) check(header http.Header, body []byte, templateData templateData) error {
+	if a.Header != "" && header.Get(a.Header) == "" {
+		return fmt.Errorf("response has no %s header", a.Header)
+	}
+
+	if a.BodyContains != "" && !bytes.Contains(body, []byte(a.BodyContains)) {
+		return fmt.Errorf("response doesn't contain %q: %s", a.BodyContains, snippet(body, 0, 0))
+	}
+
+	if a.BodyNotContains != "" {
+		if index := bytes.Index(body, []byte(a.BodyNotContains)); index >= 0 {
+			return fmt.Errorf("response contains %q: %s", a.BodyNotContains, snippet(body, index, len(a.BodyNotContains)))
+		}
+	}
+
+	if a.Regex != "" {
+		pattern, err := regexp.Compile(a.Regex)
+		if err != nil {
+			return err
+		}
+		if !pattern.Match(body) {
+			return fmt.Errorf("response doesn't match %s: %s", a.Regex, snippet(body, 0, 0))
+		}
+	}
+
+	if a.JsonPath != "" {
+		return a.checkJsonPath(body, templateData)
+	}
+
+	return nil
+}
+
+func (a Assertion) checkJsonPath(body []byte, templateData templateData) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("response is not json: %s", snippet(body, 0, 0))
+	}
+
+	found, err := lookupJsonPath(value, a.JsonPath)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, snippet(body, 0, 0))
+	}
+	if a.Equals == "" {
+		return nil
+	}
+
+	expected, err := executeTemplate(a.Equals, templateData)
+	if err != nil {
+		return err
+	}
+	if actual := jsonString(found); actual != expected.String() {
+		return fmt.Errorf("%s is %s instead of %s: %s", a.JsonPath, actual, expected, snippet(body, 0, 0))
+	}
+
+	return nil
+}
+
+func checkAssertions(assertions []Assertion, header http.Header, body []byte, templateData templateData) error {
+	for _, assertion := range assertions {
+		if err := assertion.check(header, body, templateData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snippet returns the part of body around the length bytes at index, on a
+// single line
+func snippet(body []byte, index int, length int) string {
+	if len(body) == 0 {
+		return "empty body"
+	}
+
+	start := max(0, index-(snippetSize-length)/2)
+	end := min(len(body), start+max(snippetSize, length))
+	text := strings.ToValidUTF8(string(body[start:end]), "")
+	text = strings.TrimSpace(whitespacePattern.ReplaceAllString(text, " "))
+	if start > 0 {
+		text = "..." + text
+	}
+	if end < len(body) {
+		text += "..."
+	}
+
+	return text
+}
@@ -0,0 +1,55 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAssertions(t *testing.T) {
+	header := http.Header{"X-Transmission-Session-Id": {"abc"}}
+	body := []byte(`{"ok": false, "error": "invalid_auth", "arguments": {"peer-port": 1337}}`)
+	data := templateData{Port: 1337}
+
+	cases := []struct {
+		assertion Assertion
+		err       string
+	}{
+		{Assertion{Header: "X-Transmission-Session-Id"}, ""},
+		{Assertion{Header: "X-Missing"}, "response has no X-Missing header"},
+		{Assertion{BodyContains: "peer-port"}, ""},
+		{Assertion{BodyContains: "success"}, `response doesn't contain "success": {"ok": false`},
+		{Assertion{BodyNotContains: "Fails."}, ""},
+		{Assertion{BodyNotContains: "invalid_auth"}, `response contains "invalid_auth": {"ok": false, "error": "invalid_auth"`},
+		{Assertion{Regex: `"ok":\s*true`}, `response doesn't match "ok":\s*true`},
+		{Assertion{JsonPath: "arguments.peer-port", Equals: "{{.Port}}"}, ""},
+		{Assertion{JsonPath: "ok", Equals: "true"}, "ok is false instead of true"},
+		{Assertion{JsonPath: "result"}, "result not found in the response"},
+	}
+	for _, c := range cases {
+		err := checkAssertions([]Assertion{c.assertion}, header, body, data)
+		if c.err == "" && err != nil {
+			t.Fatalf("expected %+v to hold but got %v", c.assertion, err)
+		}
+		if c.err != "" && (err == nil || !strings.HasPrefix(err.Error(), c.err)) {
+			t.Fatalf("expected %+v to fail with %s but got %v", c.assertion, c.err, err)
+		}
+	}
+
+	if err := checkAssertions([]Assertion{{JsonPath: "ok"}}, header, []byte("Fails."), data); err == nil || err.Error() != "response is not json: Fails." {
+		t.Fatalf("expected the body in the error but got %v", err)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("a", 100) + "Fails." + strings.Repeat("b", 100)
+	cut := snippet([]byte(long), 100, len("Fails."))
+	if !strings.HasPrefix(cut, "...") || !strings.HasSuffix(cut, "...") || !strings.Contains(cut, "Fails.") || len(cut) != snippetSize+6 {
+		t.Fatalf("unexpected snippet %s", cut)
+	}
+
+	if cut := snippet([]byte("Fails.\n"), 0, 0); cut != "Fails." {
+		t.Fatalf("expected the whole body but got %q", cut)
+	}
+}
@@ -0,0 +1,363 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthDigest = "digest"
+	AuthOAuth2 = "oauth2"
+)
+
+// Tokens are refreshed a bit before they expire so a request doesn't race
+// against the expiration
+const tokenExpirySkew = 30 * time.Second
+
+var (
+	ErrNoToken = errors.New("no token configured")
+)
+
+// authenticator decorates outgoing requests with the group credentials. When
+// a request gets a 401 challenge is called and if it returns true the request
+// is authorized again and retried once.
+type authenticator interface {
+	authorize(req *http.Request) error
+	challenge(resp *http.Response) bool
+}
+
+func newAuthenticator(auth *Auth, credentials Credentials, r *Requester) (authenticator, error) {
+	if auth == nil {
+		return nil, nil
+	}
+
+	auth, err := auth.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	username, password := auth.Username, auth.Password
+	if username == "" && password == "" {
+		username, password = credentials.Username, credentials.Password
+	}
+
+	switch auth.Type {
+	case AuthBasic:
+		return &basicAuth{username: username, password: password}, nil
+	case AuthBearer:
+		if auth.Token == "" {
+			return nil, ErrNoToken
+		}
+		return &bearerAuth{token: auth.Token}, nil
+	case AuthDigest:
+		return &digestAuth{username: username, password: password}, nil
+	case AuthOAuth2:
+		return &oauth2Auth{auth: auth, requester: r}, nil
+	}
+
+	return nil, fmt.Errorf("unknown auth type %s", auth.Type)
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) authorize(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+func (a *basicAuth) challenge(resp *http.Response) bool {
+	return false
+}
+
+type bearerAuth struct {
+	token string
+}
+
+func (a *bearerAuth) authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+func (a *bearerAuth) challenge(resp *http.Response) bool {
+	return false
+}
+
+// digestAuth implements RFC 7616. The first request goes out without
+// credentials, the server challenge is remembered and reused for the rest of
+// the group incrementing the nonce count.
+type digestAuth struct {
+	username string
+	password string
+	params   map[string]string
+	nc       int
+}
+
+func (a *digestAuth) authorize(req *http.Request) error {
+	if a.params == nil {
+		return nil
+	}
+
+	a.nc++
+	h, err := digestHash(a.params["algorithm"])
+	if err != nil {
+		return err
+	}
+
+	cnonce, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+
+	nc := fmt.Sprintf("%08x", a.nc)
+	realm, nonce := a.params["realm"], a.params["nonce"]
+	uri := req.URL.RequestURI()
+
+	ha1 := digest(h, a.username, realm, a.password)
+	if strings.HasSuffix(strings.ToLower(a.params["algorithm"]), "-sess") {
+		ha1 = digest(h, ha1, nonce, cnonce)
+	}
+	ha2 := digest(h, req.Method, uri)
+
+	qop := digestQop(a.params["qop"])
+	var response string
+	if qop == "" {
+		response = digest(h, ha1, nonce, ha2)
+	} else {
+		response = digest(h, ha1, nonce, nc, cnonce, qop, ha2)
+	}
+
+	fields := []string{
+		fmt.Sprintf("username=%q", a.username),
+		fmt.Sprintf("realm=%q", realm),
+		fmt.Sprintf("nonce=%q", nonce),
+		fmt.Sprintf("uri=%q", uri),
+		fmt.Sprintf("response=%q", response),
+	}
+	if algorithm, ok := a.params["algorithm"]; ok {
+		fields = append(fields, "algorithm="+algorithm)
+	}
+	if opaque, ok := a.params["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf("opaque=%q", opaque))
+	}
+	if qop != "" {
+		fields = append(fields, "qop="+qop, "nc="+nc, fmt.Sprintf("cnonce=%q", cnonce))
+	}
+
+	req.Header.Set("Authorization", "Digest "+strings.Join(fields, ", "))
+	return nil
+}
+
+func (a *digestAuth) challenge(resp *http.Response) bool {
+	header := resp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(strings.ToLower(header), "digest ") {
+		return false
+	}
+
+	params := parseDigestChallenge(header[len("digest "):])
+	// A stale nonce is the only case where retrying with the same credentials
+	// makes sense if we already answered a challenge
+	if a.params != nil && !strings.EqualFold(params["stale"], "true") {
+		return false
+	}
+
+	a.params = params
+	a.nc = 0
+	return true
+}
+
+func parseDigestChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	for len(challenge) > 0 {
+		challenge = strings.TrimLeft(challenge, " ,")
+		key, rest, found := strings.Cut(challenge, "=")
+		if !found {
+			break
+		}
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		params[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		challenge = rest
+	}
+
+	return params
+}
+
+// Only qop=auth is supported, auth-int would require hashing the body
+func digestQop(qop string) string {
+	for _, option := range strings.Split(qop, ",") {
+		if strings.TrimSpace(option) == "auth" {
+			return "auth"
+		}
+	}
+	return ""
+}
+
+func digestHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		return md5.New, nil
+	case "SHA-256":
+		return sha256.New, nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm %s", algorithm)
+}
+
+func digest(h func() hash.Hash, parts ...string) string {
+	hasher := h()
+	hasher.Write([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	expiresAt   time.Time
+}
+
+func (t *oauth2Token) valid() bool {
+	return t.AccessToken != "" && (t.expiresAt.IsZero() || time.Now().Before(t.expiresAt))
+}
+
+// tokenCache keeps OAuth2 tokens around between syncs so we don't ask for a
+// new one on every port change
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]*oauth2Token
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{tokens: map[string]*oauth2Token{}}
+}
+
+func (c *tokenCache) get(key string) *oauth2Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens[key]
+}
+
+func (c *tokenCache) set(key string, token *oauth2Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[key] = token
+}
+
+func (c *tokenCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, key)
+}
+
+// oauth2Auth implements the client credentials grant (RFC 6749 section 4.4)
+type oauth2Auth struct {
+	auth      *Auth
+	requester *Requester
+	refreshed bool
+}
+
+func (a *oauth2Auth) cacheKey() string {
+	return strings.Join([]string{a.auth.TokenUrl, a.auth.ClientId, strings.Join(a.auth.Scopes, " ")}, "|")
+}
+
+func (a *oauth2Auth) authorize(req *http.Request) error {
+	token := a.requester.tokens.get(a.cacheKey())
+	if token == nil || !token.valid() {
+		var err error
+		token, err = a.fetchToken()
+		if err != nil {
+			return err
+		}
+		a.requester.tokens.set(a.cacheKey(), token)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return nil
+}
+
+// A 401 means the cached token was revoked or expired early, drop it and
+// fetch a fresh one, but only once per sync
+func (a *oauth2Auth) challenge(resp *http.Response) bool {
+	if a.refreshed {
+		return false
+	}
+	a.refreshed = true
+	a.requester.tokens.delete(a.cacheKey())
+	return true
+}
+
+func (a *oauth2Auth) fetchToken() (*oauth2Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.auth.Scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", a.auth.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create token request %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.auth.ClientId), url.QueryEscape(a.auth.ClientSecret))
+
+	resp, err := a.requester.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request response code is not 200 but %d instead", resp.StatusCode)
+	}
+
+	token := &oauth2Token{}
+	if err := json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return nil, fmt.Errorf("couldn't parse token response %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+	AddSecret(token.AccessToken)
+	if token.ExpiresIn > 0 {
+		token.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpirySkew)
+	}
+	slog.Debug("fetched oauth2 token", "token-url", a.auth.TokenUrl, "expires-in", token.ExpiresIn)
+
+	return token, nil
+}
@@ -0,0 +1,173 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	port := uint16(1337)
+	client := &http.Client{}
+	requester := NewRequesterWithClient(client)
+
+	t.Run("Basic auth falls back to credentials", func(t *testing.T) {
+		totalRequests := 0
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			totalRequests += 1
+			user, pass, ok := req.BasicAuth()
+			if !ok || user != "user1" || pass != "pass1" {
+				t.Fatalf("expected basic auth user1:pass1 but %s:%s received instead", user, pass)
+			}
+			return &http.Response{StatusCode: 200}, nil
+		})
+
+		errs := requester.SendRequests(port, map[string]RequestGroup{
+			"test": {
+				Credentials: Credentials{Username: "user1", Password: "pass1"},
+				Auth:        &Auth{Type: AuthBasic},
+				Requests:    []Request{{Url: "http://f.com"}, {Url: "http://f.com/2"}},
+			}}, nil)
+
+		if errs != nil {
+			t.Fatal("SendRequests failed with some errors", errs)
+		}
+		if totalRequests != 2 {
+			t.Fatalf("expected 2 request but %d received", totalRequests)
+		}
+	})
+
+	t.Run("Bearer token from file", func(t *testing.T) {
+		file, err := os.CreateTemp("", "token-")
+		if err != nil {
+			t.Fatalf("couldn't create token file %v", err)
+		}
+		file.WriteString("some-token\n")
+		file.Close()
+		t.Cleanup(func() { os.Remove(file.Name()) })
+
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			if auth := req.Header.Get("Authorization"); auth != "Bearer some-token" {
+				t.Fatalf("expected Authorization Bearer some-token but %s received instead", auth)
+			}
+			return &http.Response{StatusCode: 200}, nil
+		})
+
+		errs := requester.SendRequests(port, map[string]RequestGroup{
+			"test": {
+				Auth:     &Auth{Type: AuthBearer, TokenFile: file.Name()},
+				Requests: []Request{{Url: "http://f.com"}},
+			}}, nil)
+
+		if errs != nil {
+			t.Fatal("SendRequests failed with some errors", errs)
+		}
+	})
+
+	t.Run("Digest challenge", func(t *testing.T) {
+		totalRequests := 0
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			totalRequests += 1
+			auth := req.Header.Get("Authorization")
+			if auth == "" {
+				r := http.Response{StatusCode: 401, Header: http.Header{}}
+				r.Header.Set("WWW-Authenticate", `Digest realm="test", qop="auth", nonce="abc", opaque="xyz"`)
+				return &r, nil
+			}
+
+			params := parseDigestChallenge(strings.TrimPrefix(auth, "Digest "))
+			ha1 := digest(md5.New, "user1", "test", "pass1")
+			ha2 := digest(md5.New, req.Method, req.URL.RequestURI())
+			expected := digest(md5.New, ha1, "abc", params["nc"], params["cnonce"], "auth", ha2)
+			if params["response"] != expected {
+				t.Fatalf("expected digest response %s but %s received instead", expected, params["response"])
+			}
+			if body, _ := io.ReadAll(req.Body); string(body) != "port=1337" {
+				t.Fatalf("expected body to be resent but %s received instead", body)
+			}
+			return &http.Response{StatusCode: 200}, nil
+		})
+
+		errs := requester.SendRequests(port, map[string]RequestGroup{
+			"test": {
+				Auth: &Auth{Type: AuthDigest, Username: "user1", Password: "pass1"},
+				Requests: []Request{
+					{Method: "POST", Url: "http://f.com/set", ContentType: "application/x-www-form-urlencoded", Payload: "port={{.Port}}"},
+					{Method: "POST", Url: "http://f.com/set2", ContentType: "application/x-www-form-urlencoded", Payload: "port={{.Port}}"},
+				},
+			}}, nil)
+
+		if errs != nil {
+			t.Fatal("SendRequests failed with some errors", errs)
+		}
+		if totalRequests != 3 {
+			t.Fatalf("expected 3 request but %d received", totalRequests)
+		}
+	})
+
+	t.Run("OAuth2 token caching and refresh", func(t *testing.T) {
+		tokenRequests := 0
+		revoked := false
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/token" {
+				tokenRequests += 1
+				req.ParseForm()
+				if grant := req.PostForm.Get("grant_type"); grant != "client_credentials" {
+					t.Fatalf("expected grant_type client_credentials but %s received instead", grant)
+				}
+				if id, secret, _ := req.BasicAuth(); id != "id" || secret != "secret" {
+					t.Fatalf("expected client id:secret but %s:%s received instead", id, secret)
+				}
+				body := fmt.Sprintf(`{"access_token": "token%d", "expires_in": 3600}`, tokenRequests)
+				return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+			}
+
+			if revoked && req.Header.Get("Authorization") == "Bearer token1" {
+				return &http.Response{StatusCode: 401}, nil
+			}
+			return &http.Response{StatusCode: 200}, nil
+		})
+
+		groups := map[string]RequestGroup{
+			"test": {
+				Auth:     &Auth{Type: AuthOAuth2, TokenUrl: "http://auth.com/token", ClientId: "id", ClientSecret: "secret"},
+				Requests: []Request{{Url: "http://f.com"}, {Url: "http://f.com/2"}},
+			}}
+
+		if errs := requester.SendRequests(port, groups, nil); errs != nil {
+			t.Fatal("SendRequests failed with some errors", errs)
+		}
+		if errs := requester.SendRequests(port, groups, nil); errs != nil {
+			t.Fatal("SendRequests failed with some errors", errs)
+		}
+		if tokenRequests != 1 {
+			t.Fatalf("expected 1 token request but %d received", tokenRequests)
+		}
+
+		revoked = true
+		if errs := requester.SendRequests(port, groups, nil); errs != nil {
+			t.Fatal("SendRequests failed with some errors", errs)
+		}
+		if tokenRequests != 2 {
+			t.Fatalf("expected token to be refreshed but %d token requests received", tokenRequests)
+		}
+	})
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	params := parseDigestChallenge(`realm="a, b", nonce="n", algorithm=SHA-256, qop="auth,auth-int"`)
+	expected := map[string]string{"realm": "a, b", "nonce": "n", "algorithm": "SHA-256", "qop": "auth,auth-int"}
+	for k, v := range expected {
+		if params[k] != v {
+			t.Fatalf("expected %s to be %s but %s parsed instead", k, v, params[k])
+		}
+	}
+	if qop := digestQop(params["qop"]); qop != "auth" {
+		t.Fatalf("expected qop auth but %s instead", qop)
+	}
+}
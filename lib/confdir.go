@@ -0,0 +1,114 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ConfigExtensions = []string{".yaml", ".yml", ".toml", ".json"}
+
+// DropIn is the content of a file of the config directory
+type DropIn struct {
+	File     string
+	Settings map[string]any
+}
+
+// ConfigSources remembers in which file each group was defined so errors can
+// point to the right file, and which files still use the legacy format
+type ConfigSources struct {
+	Main   string
+	Groups map[string]string
+	Legacy []string
+}
+
+// File returns the file that defines the key path
+func (s ConfigSources) File(path string) string {
+	segments := PathSegments(path)
+	if len(segments) > 1 && segments[0] == "requests" {
+		if file, ok := s.Groups[segments[1]]; ok {
+			return file
+		}
+	}
+	return s.Main
+}
+
+func IsConfigFile(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	for _, configExt := range ConfigExtensions {
+		if ext == configExt {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigDirFiles returns the config files of dir sorted by name, hidden files
+// are ignored so editor swap files don't get loaded
+func ConfigDirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !IsConfigFile(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// MergeDropIns merges the drop-ins in order. Top level keys of later files
+// override the ones of previous files, groups can only be defined once across
+// the main config and all the drop-ins. The merged requests include the ones
+// of the main config.
+func MergeDropIns(sources *ConfigSources, mainRequests map[string]any, dropIns []DropIn) (map[string]any, error) {
+	merged := map[string]any{}
+	requests := map[string]any{}
+	errs := []error{}
+
+	for name, group := range mainRequests {
+		sources.Groups[name] = sources.Main
+		requests[name] = group
+	}
+
+	for _, dropIn := range dropIns {
+		for key, value := range dropIn.Settings {
+			if key != "requests" {
+				merged[key] = value
+				continue
+			}
+
+			groups, legacy, err := NormalizeRequests(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", dropIn.File, err))
+				continue
+			}
+			if legacy {
+				sources.Legacy = append(sources.Legacy, dropIn.File)
+			}
+
+			for name, group := range groups {
+				if file, ok := sources.Groups[name]; ok {
+					errs = append(errs, fmt.Errorf("group %s is defined in %s and %s", name, file, dropIn.File))
+					continue
+				}
+				sources.Groups[name] = dropIn.File
+				requests[name] = group
+			}
+		}
+	}
+
+	merged["requests"] = requests
+
+	return merged, errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigDirFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"20-b.toml", "10-a.yaml", "30-c.json", ".10-a.yaml.swp", "README.md"} {
+		os.WriteFile(filepath.Join(dir, name), []byte{}, 0600)
+	}
+	os.Mkdir(filepath.Join(dir, "40-dir.yaml"), 0700)
+
+	files, err := ConfigDirFiles(dir)
+	if err != nil {
+		t.Fatalf("ConfigDirFiles failed %v", err)
+	}
+
+	expected := []string{filepath.Join(dir, "10-a.yaml"), filepath.Join(dir, "20-b.toml"), filepath.Join(dir, "30-c.json")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected %v but got %v", expected, files)
+	}
+}
+
+func TestMergeDropIns(t *testing.T) {
+	group := map[string]any{"requests": []any{map[string]any{"url": "http://f.com"}}}
+
+	t.Run("Merge groups", func(t *testing.T) {
+		sources := ConfigSources{Main: "config.yaml", Groups: map[string]string{}}
+		merged, err := MergeDropIns(&sources, map[string]any{"a": group}, []DropIn{
+			{File: "10-b.yaml", Settings: map[string]any{"port-file": "/tmp/1", "requests": map[string]any{"b": group}}},
+			{File: "20-c.yaml", Settings: map[string]any{"port-file": "/tmp/2", "requests": map[string]any{"c": group}}},
+		})
+		if err != nil {
+			t.Fatalf("MergeDropIns failed %v", err)
+		}
+
+		if merged["port-file"] != "/tmp/2" {
+			t.Fatalf("expected later drop-ins to override top level keys but got %v", merged["port-file"])
+		}
+		if len(merged["requests"].(map[string]any)) != 3 {
+			t.Fatalf("expected the groups of the drop-ins but got %v", merged["requests"])
+		}
+		if sources.File("requests.c.requests[0].url") != "20-c.yaml" || sources.File("requests.a") != "config.yaml" {
+			t.Fatalf("groups are not mapped to their files %v", sources.Groups)
+		}
+	})
+
+	t.Run("Legacy drop-in", func(t *testing.T) {
+		sources := ConfigSources{Main: "config.yaml", Groups: map[string]string{}}
+		merged, err := MergeDropIns(&sources, map[string]any{}, []DropIn{
+			{File: "10-b.yaml", Settings: map[string]any{"requests": []any{map[string]any{"b": group}}}},
+		})
+		if err != nil {
+			t.Fatalf("MergeDropIns failed %v", err)
+		}
+		if _, ok := merged["requests"].(map[string]any)["b"]; !ok {
+			t.Fatalf("expected legacy groups to be merged but got %v", merged["requests"])
+		}
+		if !reflect.DeepEqual(sources.Legacy, []string{"10-b.yaml"}) {
+			t.Fatalf("expected 10-b.yaml to be reported as legacy but got %v", sources.Legacy)
+		}
+	})
+
+	t.Run("Duplicated groups", func(t *testing.T) {
+		sources := ConfigSources{Main: "config.yaml", Groups: map[string]string{}}
+		_, err := MergeDropIns(&sources, map[string]any{"a": group}, []DropIn{
+			{File: "10-a.yaml", Settings: map[string]any{"requests": map[string]any{"a": group, "b": group}}},
+			{File: "20-b.yaml", Settings: map[string]any{"requests": map[string]any{"b": group}}},
+		})
+		if err == nil {
+			t.Fatal("expected duplicated groups to fail")
+		}
+		expected := "group a is defined in config.yaml and 10-a.yaml\ngroup b is defined in 10-a.yaml and 20-b.yaml"
+		if err.Error() != expected {
+			t.Fatalf("expected %q but got %q", expected, err.Error())
+		}
+	})
+}
@@ -1,26 +1,115 @@
 /* SPDX-License-Identifier: MIT */
 package lib
 
+import (
+	"reflect"
+	"time"
+)
+
 type Request struct {
+	Method      string      `mapstructure:"method" validate:"omitempty,oneof=GET POST PUT DELETE OPTION"`
+	Url         string      `mapstructure:"url" validate:"required,http_url"`
+	ContentType string      `mapstructure:"content-type" validate:"required_with=ContentType"`
+	Payload     string      `mapstructure:"payload" validate:"required_with=ContentType"`
+	Assert      []Assertion `mapstructure:"assert"`
+}
+
+// Assertion is a rule the response of a request must follow, APIs often
+// answer 200 with an error in the body. Every rule set must hold. JsonPath
+// alone only requires the value to exist, Equals is a template.
+type Assertion struct {
+	BodyContains    string `mapstructure:"body-contains"`
+	BodyNotContains string `mapstructure:"body-not-contains"`
+	JsonPath        string `mapstructure:"json-path" validate:"required_with=Equals"`
+	Equals          string `mapstructure:"equals"`
+	Regex           string `mapstructure:"regex"`
+	Header          string `mapstructure:"header"`
+}
+
+type Credentials struct {
+	Username     string `mapstructure:"username"`
+	UsernameFile string `mapstructure:"username-file" validate:"omitempty,filepath"`
+	Password     string `mapstructure:"password"`
+	PasswordFile string `mapstructure:"password-file" validate:"omitempty,filepath"`
+}
+
+// Auth configures how every request of a group is authenticated. Basic and
+// digest fall back to the group credentials when no username/password is set.
+type Auth struct {
+	Type             string   `mapstructure:"type" validate:"required,oneof=basic bearer digest oauth2"`
+	Username         string   `mapstructure:"username"`
+	Password         string   `mapstructure:"password"`
+	PasswordFile     string   `mapstructure:"password-file" validate:"omitempty,filepath"`
+	Token            string   `mapstructure:"token"`
+	TokenFile        string   `mapstructure:"token-file" validate:"omitempty,filepath"`
+	TokenUrl         string   `mapstructure:"token-url" validate:"required_if=Type oauth2,omitempty,http_url"`
+	ClientId         string   `mapstructure:"client-id" validate:"required_if=Type oauth2"`
+	ClientSecret     string   `mapstructure:"client-secret"`
+	ClientSecretFile string   `mapstructure:"client-secret-file" validate:"omitempty,filepath"`
+	Scopes           []string `mapstructure:"scopes"`
+}
+
+// Probe is a request that reads a value back from a service. The value is
+// found with JsonPath, e.g. arguments.peer-port, with the first group of Regex
+// (or its whole match) or is the whole body otherwise. It is compared with the
+// Expected template, the port by default.
+type Probe struct {
 	Method      string `mapstructure:"method" validate:"omitempty,oneof=GET POST PUT DELETE OPTION"`
 	Url         string `mapstructure:"url" validate:"required,http_url"`
-	ContentType string `mapstructure:"content-type" validate:"required_with=ContentType"`
+	ContentType string `mapstructure:"content-type"`
 	Payload     string `mapstructure:"payload" validate:"required_with=ContentType"`
+	JsonPath    string `mapstructure:"json-path" validate:"excluded_with=Regex"`
+	Regex       string `mapstructure:"regex"`
+	Expected    string `mapstructure:"expected"`
 }
 
-type Credentials struct {
-	Username string `mapstructure:"username"`
-	Password string `mapstructure:"password"`
-}
+// RequestGroup is a set of requests sent in order in the same session. With a
+// ResyncInterval the port is applied again periodically, if there is a Check
+// only when the value it reads differs from the port. Verify confirms the port
+// was applied after the requests, the group fails otherwise. OnFailure is sent
+// when a request or the verification fails, e.g. to restore the previous port.
 type RequestGroup struct {
-	Credentials Credentials `mapstructure:"credentials"`
-	Requests    []Request   `mapstructure:"requests" validate:"required,dive"`
+	Credentials    Credentials   `mapstructure:"credentials"`
+	Auth           *Auth         `mapstructure:"auth"`
+	Requests       []Request     `mapstructure:"requests" validate:"required,dive"`
+	ResyncInterval time.Duration `mapstructure:"resync-interval"`
+	Check          *Probe        `mapstructure:"check"`
+	Verify         *Probe        `mapstructure:"verify"`
+	OnFailure      []Request     `mapstructure:"on-failure" validate:"dive"`
 }
 
 type Configuration struct {
-	Once       bool
-	ForceColor bool                    `mapstructure:"force-color"`
-	Config     string                  `mapstructure:"config"`
-	PortFile   string                  `mapstructure:"port-file" validate:"required,filepath"`
-	Requests   map[string]RequestGroup `mapstructure:"requests" validate:"gt=0,dive,required"`
+	Once              bool
+	DryRun            bool                    `mapstructure:"dry-run"`
+	Port              uint16                  `mapstructure:"port"`
+	ForceColor        bool                    `mapstructure:"force-color"`
+	LogLevel          string                  `mapstructure:"log-level" validate:"omitempty,oneof=debug info warn error"`
+	LogFormat         string                  `mapstructure:"log-format" validate:"omitempty,oneof=console text json"`
+	Config            string                  `mapstructure:"config"`
+	ConfigDir         string                  `mapstructure:"config-dir" validate:"omitempty,dirpath"`
+	PortFile          string                  `mapstructure:"port-file" validate:"required,filepath"`
+	SensitiveParams   []string                `mapstructure:"sensitive-params"`
+	MetricsListen     string                  `mapstructure:"metrics-listen" validate:"omitempty,hostname_port"`
+	HealthListen      string                  `mapstructure:"health-listen" validate:"omitempty,hostname_port"`
+	ReadyThreshold    time.Duration           `mapstructure:"ready-threshold"`
+	ApiListen         string                  `mapstructure:"api-listen" validate:"omitempty,hostname_port"`
+	ApiToken          string                  `mapstructure:"api-token"`
+	ApiTokenFile      string                  `mapstructure:"api-token-file" validate:"omitempty,filepath"`
+	HistoryFile       string                  `mapstructure:"history-file" validate:"omitempty,filepath"`
+	HistoryMaxAge     time.Duration           `mapstructure:"history-max-age"`
+	HistoryMaxEntries int                     `mapstructure:"history-max-entries" validate:"gte=0"`
+	Requests          map[string]RequestGroup `mapstructure:"requests" validate:"gt=0,dive,required"`
+}
+
+// ChangedGroups returns the groups of newGroups that are new or different from
+// the ones in oldGroups
+func ChangedGroups(oldGroups map[string]RequestGroup, newGroups map[string]RequestGroup) map[string]RequestGroup {
+	changed := map[string]RequestGroup{}
+	for name, group := range newGroups {
+		if oldGroup, ok := oldGroups[name]; !ok || !reflect.DeepEqual(oldGroup, group) {
+			changed[name] = group
+		}
+	}
+
+	return changed
 }
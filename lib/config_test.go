@@ -0,0 +1,29 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"testing"
+)
+
+func TestChangedGroups(t *testing.T) {
+	oldGroups := map[string]RequestGroup{
+		"same":    {Requests: []Request{{Url: "http://f.com"}}},
+		"changed": {Requests: []Request{{Url: "http://f.com"}}},
+		"removed": {Requests: []Request{{Url: "http://f.com"}}},
+	}
+	newGroups := map[string]RequestGroup{
+		"same":    {Requests: []Request{{Url: "http://f.com"}}},
+		"changed": {Requests: []Request{{Url: "http://f.com/2"}}},
+		"added":   {Requests: []Request{{Url: "http://f.com"}}},
+	}
+
+	changed := ChangedGroups(oldGroups, newGroups)
+	if len(changed) != 2 {
+		t.Fatalf("expected 2 changed groups but got %v", changed)
+	}
+	for _, name := range []string{"changed", "added"} {
+		if _, ok := changed[name]; !ok {
+			t.Fatalf("expected %s to be reported as changed", name)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HistoryPort  = "port"
+	HistoryGroup = "group"
+)
+
+// The history is pruned when it is set, then at most this often unless the
+// max entries are exceeded by a tenth, so retries during an outage don't
+// rewrite the whole file every time
+const historyPruneInterval = time.Hour
+
+// HistoryEntry is a line of the history file, either a port change or the
+// outcome of a group
+type HistoryEntry struct {
+	Time         time.Time `json:"time"`
+	Type         string    `json:"type"`
+	Port         uint16    `json:"port"`
+	PreviousPort uint16    `json:"previous_port,omitempty"`
+	Group        string    `json:"group,omitempty"`
+	RunID        string    `json:"run_id,omitempty"`
+	Attempt      int       `json:"attempt,omitempty"`
+	Success      bool      `json:"success,omitempty"`
+	FailedStep   int       `json:"failed_step,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// HistoryRetention limits how much history is kept, zero means no limit
+type HistoryRetention struct {
+	MaxAge     time.Duration
+	MaxEntries int
+}
+
+// HistoryFilter selects history entries, port changes are left out when only
+// failures are wanted
+type HistoryFilter struct {
+	Groups     []string
+	Since      time.Time
+	Until      time.Time
+	FailedOnly bool
+}
+
+func (f HistoryFilter) Match(entry HistoryEntry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	if entry.Type == HistoryPort {
+		return !f.FailedOnly
+	}
+	if f.FailedOnly && entry.Success {
+		return false
+	}
+	if len(f.Groups) == 0 {
+		return true
+	}
+	for _, group := range f.Groups {
+		if group == entry.Group {
+			return true
+		}
+	}
+	return false
+}
+
+// History appends port changes and group results to a JSON lines file, it is
+// fed as a Reporter and by the watch loop through RecordPort. Nothing is
+// recorded until a file is set.
+type History struct {
+	mu         sync.Mutex
+	file       string
+	retention  HistoryRetention
+	run        SyncRun
+	failedStep int
+	pruned     time.Time
+	// appended is how many entries were written since the last pruning
+	appended int
+}
+
+func NewHistory() *History {
+	return &History{}
+}
+
+// SetFile sets where the history is written and how much of it is kept
+func (h *History) SetFile(file string, retention HistoryRetention) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.file = file
+	h.retention = retention
+	h.prune()
+}
+
+func (h *History) RecordPort(port uint16, previous uint16) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.append(HistoryEntry{Time: time.Now(), Type: HistoryPort, Port: port, PreviousPort: previous})
+}
+
+func (h *History) SyncStarted(run SyncRun, groups []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.run = run
+}
+
+func (h *History) GroupStarted(group string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failedStep = 0
+}
+
+func (h *History) StepFinished(update StatusUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if update.Status == Error {
+		h.failedStep = update.Step
+	}
+}
+
+func (h *History) GroupFinished(group string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry := HistoryEntry{
+		Time: time.Now(), Type: HistoryGroup, Port: h.run.Port, Group: group,
+		RunID: h.run.ID, Attempt: h.run.Attempt, Success: err == nil,
+	}
+	if err != nil {
+		entry.FailedStep = h.failedStep
+		entry.Error = Redact(err.Error())
+	}
+	h.append(entry)
+}
+
+// SyncFinished applies the retention once the results are written, if it is
+// due
+func (h *History) SyncFinished(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	overflow := h.retention.MaxEntries > 0 && h.appended >= max(h.retention.MaxEntries/10, 1)
+	if overflow || time.Since(h.pruned) >= historyPruneInterval {
+		h.prune()
+	}
+}
+
+func (h *History) prune() {
+	if h.file == "" {
+		return
+	}
+	if err := PruneHistory(h.file, h.retention, time.Now()); err != nil {
+		slog.Warn("couldn't prune the history", "file", h.file, "error", err)
+	}
+	h.pruned = time.Now()
+	h.appended = 0
+}
+
+func (h *History) append(entry HistoryEntry) {
+	if h.file == "" {
+		return
+	}
+	if err := AppendHistory(h.file, entry); err != nil {
+		slog.Warn("couldn't write the history", "file", h.file, "error", err)
+		return
+	}
+	h.appended++
+}
+
+// AppendHistory adds entry at the end of file, creating it if needed
+func AppendHistory(file string, entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't open history file %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// ReadHistory returns the entries of file matching filter, oldest first. A
+// missing file is an empty history and broken lines are skipped.
+func ReadHistory(file string, filter HistoryFilter) ([]HistoryEntry, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return []HistoryEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open history file %w", err)
+	}
+	defer f.Close()
+
+	entries := []HistoryEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := HistoryEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// PruneHistory drops the entries older than the max age and the oldest ones
+// beyond the max entries, the file is only rewritten when something is dropped
+func PruneHistory(file string, retention HistoryRetention, now time.Time) error {
+	if retention.MaxAge == 0 && retention.MaxEntries == 0 {
+		return nil
+	}
+
+	all, err := ReadHistory(file, HistoryFilter{})
+	if err != nil {
+		return err
+	}
+
+	kept := all
+	if retention.MaxAge > 0 {
+		filter := HistoryFilter{Since: now.Add(-retention.MaxAge)}
+		kept = []HistoryEntry{}
+		for _, entry := range all {
+			if filter.Match(entry) {
+				kept = append(kept, entry)
+			}
+		}
+	}
+	if retention.MaxEntries > 0 && len(kept) > retention.MaxEntries {
+		kept = kept[len(kept)-retention.MaxEntries:]
+	}
+	if len(kept) == len(all) {
+		return nil
+	}
+
+	var b strings.Builder
+	for _, entry := range kept {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("couldn't rewrite history file %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	os.Chmod(tmp.Name(), 0644)
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// ParseHistoryTime parses a point in time given as a duration ago, e.g. 24h,
+// as a date or as an RFC 3339 time
+func ParseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, now.Location()); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %s, use a duration like 24h, a date like 2006-01-02 or an RFC 3339 time", value)
+}
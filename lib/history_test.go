@@ -0,0 +1,134 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+	history := NewHistory()
+	history.RecordPort(1337, 0)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatal("nothing is recorded without a file")
+	}
+
+	history.SetFile(file, HistoryRetention{})
+	history.RecordPort(4242, 1337)
+	history.SyncStarted(SyncRun{ID: "run", Port: 4242, Attempt: 1}, []string{"a", "b"})
+	history.GroupStarted("a")
+	history.StepFinished(StatusUpdate{Service: "a", Step: 1, Status: Success})
+	history.GroupFinished("a", nil)
+	history.GroupStarted("b")
+	history.StepFinished(StatusUpdate{Service: "b", Step: 1, Status: Success})
+	history.StepFinished(StatusUpdate{Service: "b", Step: 2, Status: Error})
+	history.GroupFinished("b", errors.New("boom"))
+	history.SyncFinished(errors.New("boom"))
+
+	entries, err := ReadHistory(file, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Type != HistoryPort || entries[0].PreviousPort != 1337 {
+		t.Fatalf("unexpected history %+v", entries)
+	}
+	if b := entries[2]; b.Group != "b" || b.Success || b.FailedStep != 2 || b.Error != "boom" || b.RunID != "run" {
+		t.Fatalf("unexpected failure entry %+v", b)
+	}
+
+	t.Run("Filter", func(t *testing.T) {
+		failed, _ := ReadHistory(file, HistoryFilter{FailedOnly: true})
+		if len(failed) != 1 || failed[0].Group != "b" {
+			t.Fatalf("expected only the failure %+v", failed)
+		}
+
+		group, _ := ReadHistory(file, HistoryFilter{Groups: []string{"a"}})
+		if len(group) != 2 || group[1].Group != "a" {
+			t.Fatalf("expected the port change and group a %+v", group)
+		}
+
+		future, _ := ReadHistory(file, HistoryFilter{Since: time.Now().Add(time.Hour)})
+		if len(future) != 0 {
+			t.Fatalf("expected nothing in the future %+v", future)
+		}
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		old := HistoryEntry{Time: time.Now().Add(-48 * time.Hour), Type: HistoryPort, Port: 1}
+		pruned := filepath.Join(t.TempDir(), "history.jsonl")
+		AppendHistory(pruned, old)
+		for _, entry := range entries {
+			AppendHistory(pruned, entry)
+		}
+
+		if err := PruneHistory(pruned, HistoryRetention{MaxAge: 24 * time.Hour}, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if kept, _ := ReadHistory(pruned, HistoryFilter{}); len(kept) != 3 || kept[0].Port != 4242 {
+			t.Fatalf("expected the old entry to be dropped %+v", kept)
+		}
+
+		if err := PruneHistory(pruned, HistoryRetention{MaxEntries: 1}, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if kept, _ := ReadHistory(pruned, HistoryFilter{}); len(kept) != 1 || kept[0].Group != "b" {
+			t.Fatalf("expected only the newest entry %+v", kept)
+		}
+	})
+}
+
+func TestHistoryPruning(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+	for port := 1; port <= 25; port++ {
+		AppendHistory(file, HistoryEntry{Time: time.Now(), Type: HistoryPort, Port: uint16(port)})
+	}
+	count := func() int {
+		entries, _ := ReadHistory(file, HistoryFilter{})
+		return len(entries)
+	}
+
+	history := NewHistory()
+	history.SetFile(file, HistoryRetention{MaxEntries: 20})
+	if count() != 20 {
+		t.Fatalf("expected the history to be pruned when set but it has %d entries", count())
+	}
+
+	sync := func() {
+		history.SyncStarted(SyncRun{ID: "run", Port: 1337, Attempt: 1}, []string{"a"})
+		history.GroupStarted("a")
+		history.GroupFinished("a", errors.New("boom"))
+		history.SyncFinished(errors.New("boom"))
+	}
+	sync()
+	if count() != 21 {
+		t.Fatalf("expected no pruning after every sync but it has %d entries", count())
+	}
+	sync()
+	if count() != 20 {
+		t.Fatalf("expected the history to be pruned past a tenth over the limit but it has %d entries", count())
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	cases := map[string]time.Time{
+		"24h":                  now.Add(-24 * time.Hour),
+		"2024-03-01":           time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"2024-03-01T10:00:00Z": time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+	}
+	for value, expected := range cases {
+		parsed, err := ParseHistoryTime(value, now)
+		if err != nil || !parsed.Equal(expected) {
+			t.Fatalf("expected %s to be %s but got %s %v", value, expected, parsed, err)
+		}
+	}
+
+	if _, err := ParseHistoryTime("yesterday", now); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
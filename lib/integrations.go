@@ -0,0 +1,180 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// DefaultPortFile is where gluetun writes the forwarded port
+const DefaultPortFile = "/tmp/gluetun/forwarded_port"
+
+// IntegrationField is a value init asks for, Secret ones aren't echoed and can
+// be kept in the environment
+type IntegrationField struct {
+	Name     string
+	Prompt   string
+	Default  string
+	Required bool
+	Secret   bool
+}
+
+// Integration is a ready made request group for a well known service
+type Integration struct {
+	Name   string
+	Title  string
+	Fields []IntegrationField
+	Build  func(values map[string]string) RequestGroup
+}
+
+var Integrations = []Integration{
+	{
+		Name:  "qbittorrent",
+		Title: "qBittorrent",
+		Fields: []IntegrationField{
+			{Name: "url", Prompt: "Web UI url", Default: "http://localhost:8080", Required: true},
+			{Name: "username", Prompt: "Username", Default: "admin"},
+			{Name: "password", Prompt: "Password", Secret: true},
+		},
+		Build: func(values map[string]string) RequestGroup {
+			url := strings.TrimSuffix(values["url"], "/")
+			return RequestGroup{
+				Credentials: Credentials{Username: values["username"], Password: values["password"]},
+				Requests: []Request{
+					{
+						Method:      "POST",
+						Url:         url + "/api/v2/auth/login",
+						ContentType: "application/x-www-form-urlencoded",
+						Payload:     "username={{.Username}}&password={{.Password}}",
+						// A wrong password is a 200 too
+						Assert: []Assertion{{BodyNotContains: "Fails."}},
+					},
+					{
+						Method:      "POST",
+						Url:         url + "/api/v2/app/setPreferences",
+						ContentType: "application/x-www-form-urlencoded",
+						Payload:     `json={"listen_port": {{.Port}}}`,
+					},
+				},
+			}
+		},
+	},
+	{
+		Name:  "transmission",
+		Title: "Transmission",
+		Fields: []IntegrationField{
+			{Name: "url", Prompt: "RPC url", Default: "http://localhost:9091/transmission/rpc", Required: true},
+			{Name: "username", Prompt: "Username (empty if there is no authentication)"},
+			{Name: "password", Prompt: "Password", Secret: true},
+		},
+		Build: func(values map[string]string) RequestGroup {
+			group := RequestGroup{
+				Requests: []Request{{
+					Method:      "POST",
+					Url:         values["url"],
+					ContentType: "application/json",
+					Payload:     `{"method": "session-set", "arguments": {"peer-port": {{.Port}}}}`,
+					Assert:      []Assertion{{JsonPath: "result", Equals: "success"}},
+				}},
+			}
+			if values["username"] != "" {
+				group.Credentials = Credentials{Username: values["username"], Password: values["password"]}
+				group.Auth = &Auth{Type: AuthBasic}
+			}
+			return group
+		},
+	},
+	{
+		Name:  "slack",
+		Title: "Slack (or Mattermost) notification",
+		Fields: []IntegrationField{
+			{Name: "webhook-url", Prompt: "Incoming webhook url", Required: true, Secret: true},
+		},
+		Build: func(values map[string]string) RequestGroup {
+			return notification(values["webhook-url"], "application/json", `{"text": "Forwarded port changed to {{.Port}}"}`)
+		},
+	},
+	{
+		Name:  "discord",
+		Title: "Discord notification",
+		Fields: []IntegrationField{
+			{Name: "webhook-url", Prompt: "Webhook url", Required: true, Secret: true},
+		},
+		Build: func(values map[string]string) RequestGroup {
+			return notification(values["webhook-url"], "application/json", `{"content": "Forwarded port changed to {{.Port}}"}`)
+		},
+	},
+	{
+		Name:  "ntfy",
+		Title: "ntfy notification",
+		Fields: []IntegrationField{
+			{Name: "url", Prompt: "Topic url", Default: "https://ntfy.sh/gluetun-sync", Required: true},
+		},
+		Build: func(values map[string]string) RequestGroup {
+			return notification(values["url"], "text/plain", "Forwarded port changed to {{.Port}}")
+		},
+	},
+}
+
+func notification(url string, contentType string, payload string) RequestGroup {
+	return RequestGroup{
+		Requests: []Request{{Method: "POST", Url: url, ContentType: contentType, Payload: payload}},
+	}
+}
+
+// Settings converts a configuration value into the map of settings it is
+// decoded from, zero values are left out and durations are written as strings
+func Settings(value any) any {
+	return settings(reflect.ValueOf(value))
+}
+
+func settings(v reflect.Value) any {
+	if v.IsValid() && v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return settings(v.Elem())
+	case reflect.Struct:
+		result := map[string]any{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			if !field.IsExported() || v.Field(i).IsZero() {
+				continue
+			}
+
+			value := settings(v.Field(i))
+			if options == "squash" {
+				for key, value := range value.(map[string]any) {
+					result[key] = value
+				}
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			result[name] = value
+		}
+		return result
+	case reflect.Map:
+		result := map[string]any{}
+		for _, key := range v.MapKeys() {
+			result[key.String()] = settings(v.MapIndex(key))
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		result := make([]any, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result[i] = settings(v.Index(i))
+		}
+		return result
+	}
+
+	return v.Interface()
+}
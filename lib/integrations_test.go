@@ -0,0 +1,38 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+func TestIntegrations(t *testing.T) {
+	for _, integration := range Integrations {
+		t.Run(integration.Name, func(t *testing.T) {
+			values := map[string]string{}
+			for _, field := range integration.Fields {
+				values[field.Name] = field.Default
+				if values[field.Name] == "" {
+					values[field.Name] = "http://" + field.Name + ".test"
+				}
+			}
+
+			c := Configuration{PortFile: DefaultPortFile, Requests: map[string]RequestGroup{
+				integration.Name: integration.Build(values),
+			}}
+			if errs := ValidateConfig(c); len(errs) > 0 {
+				t.Fatalf("generated configuration is not valid %v", errs)
+			}
+
+			decoded := Configuration{}
+			if err := mapstructure.Decode(Settings(c), &decoded); err != nil {
+				t.Fatalf("couldn't decode settings %v", err)
+			}
+			if !reflect.DeepEqual(c, decoded) {
+				t.Fatalf("settings don't decode back into the configuration\n%+v\n%+v", c, decoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	LogFormatConsole = "console"
+	LogFormatText    = "text"
+	LogFormatJson    = "json"
+)
+
+// SetupLogger replaces the default slog logger, every component logs through
+// it. Secrets are redacted whatever the format is.
+func SetupLogger(w io.Writer, format string, level string) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %s", level)
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch format {
+	case LogFormatConsole:
+		handler = NewConsoleHandler(w, options)
+	case LogFormatText:
+		handler = slog.NewTextHandler(w, options)
+	case LogFormatJson:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %s", format)
+	}
+
+	slog.SetDefault(slog.New(redactHandler{handler}))
+	return nil
+}
+
+// redactHandler redacts the message and the string attributes of every record
+type redactHandler struct {
+	handler slog.Handler
+}
+
+func (h redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h redactHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, Redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.handler.Handle(ctx, redacted)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return redactHandler{h.handler.WithAttrs(redacted)}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{h.handler.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		attrs := make([]any, len(group))
+		for i, attr := range group {
+			attrs[i] = redactAttr(attr)
+		}
+		return slog.Group(attr.Key, attrs...)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(attr.Key, Redact(err.Error()))
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+// ConsoleHandler prints records for humans, the message followed by its
+// attributes colored by level
+type ConsoleHandler struct {
+	mu      *sync.Mutex
+	w       io.Writer
+	options slog.HandlerOptions
+	prefix  string
+	attrs   string
+}
+
+func NewConsoleHandler(w io.Writer, options *slog.HandlerOptions) *ConsoleHandler {
+	h := &ConsoleHandler{mu: &sync.Mutex{}, w: w}
+	if options != nil {
+		h.options = *options
+	}
+	return h
+}
+
+func (h *ConsoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.options.Level != nil {
+		minLevel = h.options.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *ConsoleHandler) Handle(_ context.Context, record slog.Record) error {
+	var attrs bytes.Buffer
+	attrs.WriteString(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		appendConsoleAttr(&attrs, h.prefix, attr)
+		return true
+	})
+
+	var message string
+	switch {
+	case record.Level >= slog.LevelError:
+		message = color.New(color.FgRed).Sprint(record.Message + attrs.String())
+	case record.Level >= slog.LevelWarn:
+		message = color.New(color.FgYellow).Sprint(record.Message + attrs.String())
+	case record.Level >= slog.LevelInfo:
+		message = color.New(color.Bold).Sprint(record.Message) + attrs.String()
+	default:
+		message = color.New(color.Faint).Sprint(record.Message + attrs.String())
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, message+"\n")
+	return err
+}
+
+func (h *ConsoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var buf bytes.Buffer
+	buf.WriteString(h.attrs)
+	for _, attr := range attrs {
+		appendConsoleAttr(&buf, h.prefix, attr)
+	}
+
+	handler := *h
+	handler.attrs = buf.String()
+	return &handler
+}
+
+func (h *ConsoleHandler) WithGroup(name string) slog.Handler {
+	handler := *h
+	handler.prefix = h.prefix + name + "."
+	return &handler
+}
+
+func appendConsoleAttr(buf *bytes.Buffer, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if value.Kind() == slog.KindGroup {
+		for _, groupAttr := range value.Group() {
+			appendConsoleAttr(buf, prefix+attr.Key+".", groupAttr)
+		}
+		return
+	}
+
+	var text string
+	switch value.Kind() {
+	case slog.KindDuration:
+		duration := value.Duration()
+		if duration > time.Millisecond {
+			duration = duration.Round(time.Millisecond)
+		}
+		text = duration.String()
+	default:
+		text = value.String()
+	}
+	if text == "" || strings.ContainsAny(text, " \"=") {
+		text = strconv.Quote(text)
+	}
+
+	fmt.Fprintf(buf, " %s%s=%s", prefix, attr.Key, text)
+}
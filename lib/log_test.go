@@ -0,0 +1,67 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+func TestLogger(t *testing.T) {
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	AddSecret("logsecret")
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := SetupLogger(&buf, LogFormatJson, "info"); err != nil {
+			t.Fatal(err)
+		}
+
+		slog.Debug("hidden")
+		slog.With("group", "qbittorrent").Error("request failed", "url", "http://f.com/?token=logsecret", "error", errors.New("bad logsecret"), "status", 401)
+
+		record := map[string]any{}
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("expected a single json record %v %s", err, buf.String())
+		}
+		if record["level"] != "ERROR" || record["group"] != "qbittorrent" || record["status"] != float64(401) {
+			t.Fatalf("unexpected record %v", record)
+		}
+		if strings.Contains(buf.String(), "logsecret") {
+			t.Fatalf("secret was not redacted %s", buf.String())
+		}
+	})
+
+	t.Run("Console", func(t *testing.T) {
+		noColor := color.NoColor
+		color.NoColor = true
+		t.Cleanup(func() { color.NoColor = noColor })
+
+		var buf bytes.Buffer
+		if err := SetupLogger(&buf, LogFormatConsole, "debug"); err != nil {
+			t.Fatal(err)
+		}
+
+		slog.Debug("request succeeded", "group", "a", "duration", 1234567*time.Nanosecond, "error", errors.New("token logsecret"))
+		expected := "request succeeded group=a duration=1ms error=\"token ****\"\n"
+		if buf.String() != expected {
+			t.Fatalf("expected %q but %q", expected, buf.String())
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if err := SetupLogger(&bytes.Buffer{}, "xml", "info"); err == nil {
+			t.Fatal("expected an error for an unknown format")
+		}
+		if err := SetupLogger(&bytes.Buffer{}, LogFormatText, "loud"); err == nil {
+			t.Fatal("expected an error for an unknown level")
+		}
+	})
+}
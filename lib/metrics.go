@@ -0,0 +1,200 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DurationBuckets are the upper bounds in seconds of the request duration
+// histogram
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range DurationBuckets {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+type stepKey struct {
+	group string
+	step  int
+}
+
+// Metrics keeps the counters exported in the Prometheus text format, it is
+// fed as a Reporter and by the port watcher through ObservePort
+type Metrics struct {
+	mu          sync.Mutex
+	port        uint16
+	portChanges uint64
+	runs        map[string]uint64
+	failures    map[string]uint64
+	retries     map[string]uint64
+	lastSuccess map[string]time.Time
+	durations   map[stepKey]*histogram
+	attempt     int
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		runs:        map[string]uint64{},
+		failures:    map[string]uint64{},
+		retries:     map[string]uint64{},
+		lastSuccess: map[string]time.Time{},
+		durations:   map[stepKey]*histogram{},
+	}
+}
+
+// ObservePort records the current forwarded port, a different port than the
+// previous one counts as a change
+func (m *Metrics) ObservePort(port uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.port != 0 && m.port != port {
+		m.portChanges++
+	}
+	m.port = port
+}
+
+func (m *Metrics) SyncStarted(run SyncRun, groups []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.attempt = run.Attempt
+}
+
+func (m *Metrics) GroupStarted(group string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.attempt > 1 {
+		m.retries[group]++
+	}
+}
+
+func (m *Metrics) StepFinished(update StatusUpdate) {
+	if update.Duration == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := stepKey{update.Service, update.Step}
+	h, ok := m.durations[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(DurationBuckets))}
+		m.durations[key] = h
+	}
+	h.observe(update.Duration.Seconds())
+}
+
+func (m *Metrics) GroupFinished(group string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.runs[group]++
+	if err != nil {
+		m.failures[group]++
+		return
+	}
+	m.lastSuccess[group] = time.Now()
+}
+
+func (m *Metrics) SyncFinished(err error) {}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	writeHeader(&b, "gluetun_sync_port", "gauge", "Current forwarded port.")
+	fmt.Fprintf(&b, "gluetun_sync_port %d\n", m.port)
+
+	writeHeader(&b, "gluetun_sync_port_changes_total", "counter", "Times the forwarded port changed.")
+	fmt.Fprintf(&b, "gluetun_sync_port_changes_total %d\n", m.portChanges)
+
+	writeGroupCounter(&b, "gluetun_sync_group_runs_total", "Times each group was synchronized.", m.runs)
+	writeGroupCounter(&b, "gluetun_sync_group_failures_total", "Times each group failed to synchronize.", m.failures)
+	writeGroupCounter(&b, "gluetun_sync_group_retries_total", "Times each group was retried after a failure.", m.retries)
+
+	writeHeader(&b, "gluetun_sync_group_last_success_timestamp_seconds", "gauge", "Unix time of the last successful sync of each group.")
+	for _, group := range sortedKeys(m.lastSuccess) {
+		fmt.Fprintf(&b, "gluetun_sync_group_last_success_timestamp_seconds{group=%s} %d\n", labelValue(group), m.lastSuccess[group].Unix())
+	}
+
+	writeHeader(&b, "gluetun_sync_request_duration_seconds", "histogram", "Duration of the requests of each group step.")
+	keys := make([]stepKey, 0, len(m.durations))
+	for key := range m.durations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].step < keys[j].step
+	})
+	for _, key := range keys {
+		h := m.durations[key]
+		labels := fmt.Sprintf("group=%s,step=\"%d\"", labelValue(key.group), key.step)
+		for i, bound := range DurationBuckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(&b, "gluetun_sync_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, le, h.buckets[i])
+		}
+		fmt.Fprintf(&b, "gluetun_sync_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&b, "gluetun_sync_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "gluetun_sync_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeHeader(b *strings.Builder, name string, metricType string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeGroupCounter(b *strings.Builder, name string, help string, values map[string]uint64) {
+	writeHeader(b, name, "counter", help)
+	for _, group := range sortedKeys(values) {
+		fmt.Fprintf(b, "%s{group=%s} %d\n", name, labelValue(group), values[group])
+	}
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(value string) string {
+	return `"` + labelReplacer.Replace(value) + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,58 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.ObservePort(1337)
+	metrics.ObservePort(1337)
+	metrics.ObservePort(4242)
+
+	run := SyncRun{ID: "run", Port: 4242, Attempt: 1}
+	metrics.SyncStarted(run, []string{"a", "b"})
+	metrics.GroupStarted("a")
+	metrics.StepFinished(StatusUpdate{Service: "a", Step: 1, Duration: 30 * time.Millisecond})
+	metrics.GroupFinished("a", nil)
+	metrics.GroupStarted("b")
+	metrics.StepFinished(StatusUpdate{Service: "b", Step: 1, Duration: 2 * time.Second})
+	metrics.GroupFinished("b", errors.New("boom"))
+
+	run.Attempt = 2
+	metrics.SyncStarted(run, []string{"b"})
+	metrics.GroupStarted("b")
+	metrics.GroupFinished("b", errors.New("boom"))
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	expected := []string{
+		"gluetun_sync_port 4242\n",
+		"gluetun_sync_port_changes_total 1\n",
+		`gluetun_sync_group_runs_total{group="a"} 1`,
+		`gluetun_sync_group_runs_total{group="b"} 2`,
+		`gluetun_sync_group_failures_total{group="b"} 2`,
+		`gluetun_sync_group_retries_total{group="b"} 1`,
+		`gluetun_sync_group_last_success_timestamp_seconds{group="a"} `,
+		`gluetun_sync_request_duration_seconds_bucket{group="a",step="1",le="0.025"} 0`,
+		`gluetun_sync_request_duration_seconds_bucket{group="a",step="1",le="0.05"} 1`,
+		`gluetun_sync_request_duration_seconds_bucket{group="b",step="1",le="+Inf"} 1`,
+		`gluetun_sync_request_duration_seconds_count{group="b",step="1"} 1`,
+		"# TYPE gluetun_sync_request_duration_seconds histogram",
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected %q in metrics\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `last_success_timestamp_seconds{group="b"}`) {
+		t.Fatal("b never succeeded")
+	}
+}
@@ -0,0 +1,199 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// NormalizeRequests converts the legacy requests format, a list of single key
+// maps, into the map of groups. legacy is true if value used the old format.
+func NormalizeRequests(value any) (groups map[string]any, legacy bool, err error) {
+	switch value := value.(type) {
+	case nil:
+		return map[string]any{}, false, nil
+	case map[string]any:
+		return value, false, nil
+	case []map[string]any:
+		list := make([]any, len(value))
+		for i, item := range value {
+			list[i] = item
+		}
+		return normalizeList(list)
+	case []any:
+		return normalizeList(value)
+	}
+
+	return nil, false, fmt.Errorf("requests must be a map of groups but is %T", value)
+}
+
+func normalizeList(list []any) (map[string]any, bool, error) {
+	groups := map[string]any{}
+	definedAt := map[string]int{}
+	errs := []error{}
+
+	for i, item := range list {
+		itemGroups, ok := item.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("requests[%d] is not a map of groups", i))
+			continue
+		}
+
+		for name, group := range itemGroups {
+			if j, ok := definedAt[name]; ok {
+				errs = append(errs, fmt.Errorf("group %s is defined in requests[%d] and requests[%d]", name, j, i))
+				continue
+			}
+			definedAt[name] = i
+			groups[name] = group
+		}
+	}
+
+	return groups, true, errors.Join(errs...)
+}
+
+// MigrateConfig rewrites a config file content in the canonical format. YAML
+// is migrated in place so comments and key order are kept, JSON and TOML are
+// decoded and encoded again. changed is false if there was nothing to migrate.
+func MigrateConfig(content []byte, file string) (migrated []byte, changed bool, err error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return migrateYaml(content)
+	case ".json":
+		settings := map[string]any{}
+		if err := json.Unmarshal(content, &settings); err != nil {
+			return nil, false, err
+		}
+		if changed, err := migrateSettings(settings); !changed || err != nil {
+			return content, false, err
+		}
+
+		migrated, err := EncodeConfig(settings, file)
+		return migrated, true, err
+	case ".toml":
+		settings := map[string]any{}
+		if err := toml.Unmarshal(content, &settings); err != nil {
+			return nil, false, err
+		}
+		if changed, err := migrateSettings(settings); !changed || err != nil {
+			return content, false, err
+		}
+
+		migrated, err := EncodeConfig(settings, file)
+		return migrated, true, err
+	}
+
+	return nil, false, fmt.Errorf("unsupported config format %s", filepath.Ext(file))
+}
+
+// EncodeConfig encodes settings in the format given by the extension of file
+func EncodeConfig(settings map[string]any, file string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		err := encoder.Encode(settings)
+		return buf.Bytes(), err
+	case ".json":
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(settings)
+		return buf.Bytes(), err
+	case ".toml":
+		return toml.Marshal(settings)
+	}
+
+	return nil, fmt.Errorf("unsupported config format %s", filepath.Ext(file))
+}
+
+func migrateSettings(settings map[string]any) (bool, error) {
+	for key, value := range settings {
+		if !strings.EqualFold(key, "requests") {
+			continue
+		}
+
+		groups, legacy, err := NormalizeRequests(value)
+		if err != nil || !legacy {
+			return false, err
+		}
+		settings[key] = groups
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func migrateYaml(content []byte) ([]byte, bool, error) {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, false, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return content, false, nil
+	}
+
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if !strings.EqualFold(root.Content[i].Value, "requests") {
+			continue
+		}
+
+		list := root.Content[i+1]
+		if list.Kind != yaml.SequenceNode {
+			return content, false, nil
+		}
+
+		groups, err := yamlGroups(list)
+		if err != nil {
+			return nil, false, err
+		}
+		root.Content[i+1] = groups
+
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&doc); err != nil {
+			return nil, false, err
+		}
+		return buf.Bytes(), true, nil
+	}
+
+	return content, false, nil
+}
+
+func yamlGroups(list *yaml.Node) (*yaml.Node, error) {
+	groups := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", HeadComment: list.HeadComment, LineComment: list.LineComment}
+	definedAt := map[string]int{}
+	errs := []error{}
+
+	for i, item := range list.Content {
+		if item.Kind != yaml.MappingNode {
+			errs = append(errs, fmt.Errorf("requests[%d] at line %d is not a map of groups", i, item.Line))
+			continue
+		}
+
+		for j := 0; j+1 < len(item.Content); j += 2 {
+			name := item.Content[j].Value
+			if k, ok := definedAt[name]; ok {
+				errs = append(errs, fmt.Errorf("group %s at line %d is already defined at line %d", name, item.Content[j].Line, k))
+				continue
+			}
+			definedAt[name] = item.Content[j].Line
+			if j == 0 && item.HeadComment != "" {
+				item.Content[j].HeadComment = strings.TrimSpace(item.HeadComment + "\n" + item.Content[j].HeadComment)
+			}
+			groups.Content = append(groups.Content, item.Content[j], item.Content[j+1])
+		}
+	}
+
+	return groups, errors.Join(errs...)
+}
@@ -0,0 +1,74 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeRequests(t *testing.T) {
+	group := map[string]any{"requests": []any{}}
+
+	groups, legacy, err := NormalizeRequests([]any{map[string]any{"a": group}, map[string]any{"b": group}})
+	if err != nil || !legacy || len(groups) != 2 {
+		t.Fatalf("expected 2 legacy groups but got %v %v %v", groups, legacy, err)
+	}
+
+	groups, legacy, err = NormalizeRequests(map[string]any{"a": group})
+	if err != nil || legacy || len(groups) != 1 {
+		t.Fatalf("expected the map to be returned as is but got %v %v %v", groups, legacy, err)
+	}
+
+	_, _, err = NormalizeRequests([]any{"a", map[string]any{"b": group}, map[string]any{"b": group}})
+	if err == nil || !strings.Contains(err.Error(), "requests[0]") || !strings.Contains(err.Error(), "group b") {
+		t.Fatalf("expected non map items and duplicated groups to be reported but got %v", err)
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	t.Run("YAML keeps comments", func(t *testing.T) {
+		migrated, changed, err := MigrateConfig([]byte(`port-file: "/tmp/portfile"
+requests:
+  # torrent client
+  - torrent:
+      requests:
+        - url: "http://f.com"
+`), "config.yaml")
+		if err != nil || !changed {
+			t.Fatalf("expected the config to be migrated %v", err)
+		}
+
+		expected := `port-file: "/tmp/portfile"
+requests:
+  # torrent client
+  torrent:
+    requests:
+      - url: "http://f.com"
+`
+		if string(migrated) != expected {
+			t.Fatalf("expected\n%s\nbut got\n%s", expected, migrated)
+		}
+	})
+
+	t.Run("TOML", func(t *testing.T) {
+		migrated, changed, err := MigrateConfig([]byte(`[[requests]]
+  [requests.slack]
+    [[requests.slack.requests]]
+      url = "http://f.com"
+`), "config.toml")
+		if err != nil || !changed {
+			t.Fatalf("expected the config to be migrated %v", err)
+		}
+		if !strings.Contains(string(migrated), "[[requests.slack.requests]]") || strings.Contains(string(migrated), "[[requests]]") {
+			t.Fatalf("unexpected migrated config %s", migrated)
+		}
+	})
+
+	t.Run("Canonical config is untouched", func(t *testing.T) {
+		content := []byte(`{"requests": {"a": {"requests": []}}}`)
+		migrated, changed, err := MigrateConfig(content, "config.json")
+		if err != nil || changed || string(migrated) != string(content) {
+			t.Fatalf("expected nothing to migrate but got %s %v %v", migrated, changed, err)
+		}
+	})
+}
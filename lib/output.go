@@ -3,63 +3,29 @@ package lib
 
 import (
 	"fmt"
-	"net/url"
-
-	"github.com/fatih/color"
-)
-
-var (
-	bold color.Color = *color.New(color.Bold)
+	"sort"
 )
 
-func Info(info string) {
-	bold.Print(info)
+func PrintService(service string) {
+	fmt.Printf("🔁 Service %s\n", service)
 }
 
-func printRequesterError(err error) {
-	if err == nil {
-		return
-	}
+func PrintRendered(request RenderedRequest) {
+	fmt.Printf("  └─ %s %s\n", request.Method, RedactURL(Redact(request.Url)))
 
-	switch err := err.(type) {
-	case *url.Error:
-		PrintStepError(err.Err)
-	default:
-		PrintStepError(err)
+	header := RedactHeader(request.Header)
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
 	}
-}
-
-func PrintStepError(err error) {
-	color.Red("     %s\n", err)
-}
-
-// Channel will be closed by requester :/
-func PrintRequester() (chan StatusUpdate, chan struct{}) {
-	updateCh := make(chan StatusUpdate)
-	quitCh := make(chan struct{})
-	go func() {
-		for update := range updateCh {
-			if update.Step == 1 {
-				fmt.Printf("🔁 Service %s\n", update.Service)
-			}
-			if update.Status == UnInitialized {
-				continue
-			}
-			status := "✅"
-			if update.Status == Error {
-				status = "❌"
-			}
-			fmt.Printf("  └─ %s %s %s\n", update.Method, update.Path, status)
-			if update.Error != nil {
-				printRequesterError(update.Error)
-			}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Printf("     %s: %s\n", key, value)
 		}
-		close(quitCh)
-	}()
-
-	return updateCh, quitCh
-}
+	}
 
-func PrintError(err error) {
-	color.Red("%s\n", err)
+	if request.Body != "" {
+		fmt.Printf("     %s\n", Redact(request.Body))
+	}
 }
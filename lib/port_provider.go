@@ -4,7 +4,7 @@ package lib
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -38,12 +38,20 @@ func PortChangeNotifier(portFile string, throttleTimeMs uint) (chan uint16, chan
 
 	go func() {
 		defer watcher.Close()
+		// Closing portCh tells the receiver that the file is not watched anymore
+		defer close(portCh)
 		port, err := GetPortFromFile(portFile)
 		if err == nil {
-			portCh <- port
+			select {
+			case portCh <- port:
+			case <-quit:
+				return
+			}
 		}
 
+		// The timer is only touched from this goroutine
 		var timer *time.Timer
+		var timerCh <-chan time.Time
 		for {
 			select {
 			case event, ok := <-watcher.Events:
@@ -57,23 +65,29 @@ func PortChangeNotifier(portFile string, throttleTimeMs uint) (chan uint16, chan
 					timer.Reset(time.Second)
 					continue
 				}
-				timer = time.AfterFunc(throttleDuration, func() {
-					newPort, err := GetPortFromFile(portFile)
-					if err != nil {
-						fmt.Println("error loading new port file", err)
+				timer = time.NewTimer(throttleDuration)
+				timerCh = timer.C
+			case <-timerCh:
+				timer, timerCh = nil, nil
+				newPort, err := GetPortFromFile(portFile)
+				if err != nil {
+					slog.Error("error loading new port file", "file", portFile, "error", err)
+					continue
+				}
+				slog.Debug("port file changed", "file", portFile, "port", newPort)
+				if newPort != port {
+					port = newPort
+					select {
+					case portCh <- port:
+					case <-quit:
 						return
 					}
-					if newPort != port {
-						port = newPort
-						portCh <- port
-					}
-					timer = nil
-				})
+				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
 				}
-				log.Println("error", err)
+				slog.Error("error watching port file", "file", portFile, "error", err)
 			case <-quit:
 				return
 			}
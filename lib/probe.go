@@ -0,0 +1,116 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func (p Probe) request() Request {
+	return Request{Method: p.Method, Url: p.Url, ContentType: p.ContentType, Payload: p.Payload}
+}
+
+// expected renders the value the probe should read
+func (p Probe) expected(templateData templateData) (string, error) {
+	expected := p.Expected
+	if expected == "" {
+		expected = "{{.Port}}"
+	}
+
+	value, err := executeTemplate(expected, templateData)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(value.String()), nil
+}
+
+// Extract returns the value the probe reads from body
+func (p Probe) Extract(body []byte) (string, error) {
+	switch {
+	case p.JsonPath != "":
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return "", fmt.Errorf("couldn't parse the response as json %w", err)
+		}
+		found, err := lookupJsonPath(value, p.JsonPath)
+		if err != nil {
+			return "", err
+		}
+		return jsonString(found), nil
+	case p.Regex != "":
+		pattern, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return "", err
+		}
+		match := pattern.FindSubmatch(body)
+		if match == nil {
+			return "", fmt.Errorf("%s doesn't match the response", p.Regex)
+		}
+		if len(match) > 1 {
+			return string(match[1]), nil
+		}
+		return string(match[0]), nil
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+// lookupJsonPath follows a path like $.items[0].port into value, keys are
+// separated by dots and array elements are selected with [index]
+func lookupJsonPath(value any, path string) (any, error) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	for rest != "" {
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in json path %s", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %s in json path %s", rest[1:end], path)
+			}
+			list, ok := value.([]any)
+			if !ok || index < 0 || index >= len(list) {
+				return nil, fmt.Errorf("%s not found in the response", path)
+			}
+			value = list[index]
+			rest = strings.TrimPrefix(rest[end+1:], ".")
+			continue
+		}
+
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s not found in the response", path)
+		}
+		if value, ok = object[rest[:end]]; !ok {
+			return nil, fmt.Errorf("%s not found in the response", path)
+		}
+		rest = strings.TrimPrefix(rest[end:], ".")
+	}
+
+	return value, nil
+}
+
+// jsonString formats a decoded json value, strings and numbers as they are
+// and anything else as json
+func jsonString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+
+	content, _ := json.Marshal(value)
+	return string(content)
+}
@@ -0,0 +1,40 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"testing"
+)
+
+func TestProbeExtract(t *testing.T) {
+	body := []byte(`{"listen_port": 1337, "arguments": {"peer-port": "4242", "items": [{"port": 1}, {"port": 2}]}, "upnp": true}`)
+
+	cases := []struct {
+		probe    Probe
+		expected string
+	}{
+		{Probe{JsonPath: "listen_port"}, "1337"},
+		{Probe{JsonPath: "$.arguments.peer-port"}, "4242"},
+		{Probe{JsonPath: "arguments.items[1].port"}, "2"},
+		{Probe{JsonPath: "upnp"}, "true"},
+		{Probe{JsonPath: "arguments.items[0]"}, `{"port":1}`},
+		{Probe{Regex: `"listen_port": (\d+)`}, "1337"},
+		{Probe{Regex: `\d{4}`}, "1337"},
+		{Probe{}, string(body)},
+	}
+	for _, c := range cases {
+		value, err := c.probe.Extract(body)
+		if err != nil || value != c.expected {
+			t.Fatalf("expected %+v to read %s but got %s %v", c.probe, c.expected, value, err)
+		}
+	}
+
+	for _, probe := range []Probe{{JsonPath: "missing"}, {JsonPath: "arguments.items[5].port"}, {JsonPath: "listen_port.nested"}, {Regex: "nope"}} {
+		if value, err := probe.Extract(body); err == nil {
+			t.Fatalf("expected %+v to fail but got %s", probe, value)
+		}
+	}
+
+	if _, err := (Probe{JsonPath: "port"}).Extract([]byte("Ok.")); err == nil {
+		t.Fatal("expected an error for a body that isn't json")
+	}
+}
@@ -0,0 +1,224 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const Redacted = "****"
+
+// Secrets shorter than this are not redacted from free text, otherwise a two
+// letters password would mangle every message
+const minSecretLength = 3
+
+var DefaultSensitiveParams = []string{
+	"password", "passwd", "pass", "pwd", "token", "access_token", "api_key", "apikey", "key", "secret", "client_secret", "auth",
+}
+
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Webhook urls carry their token in the path, what follows these is masked:
+// Slack /services/T0/B0/token, Discord /api/webhooks/id/token and Mattermost
+// /hooks/id
+var webhookPaths = []string{"/services/", "/api/webhooks/", "/hooks/"}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// Redactor masks secrets before they reach any output. It knows about the
+// secret values found in the configuration and about query params that should
+// never be printed.
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+	params  map[string]struct{}
+}
+
+var redactor = NewRedactor()
+
+func NewRedactor() *Redactor {
+	r := &Redactor{}
+	r.SetSensitiveParams(nil)
+	return r
+}
+
+// SetSensitiveParams sets the query params to redact on top of the defaults
+func (r *Redactor) SetSensitiveParams(params []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.params = map[string]struct{}{}
+	for _, param := range append(DefaultSensitiveParams, params...) {
+		r.params[strings.ToLower(param)] = struct{}{}
+	}
+}
+
+func (r *Redactor) AddSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.secrets {
+		if s == secret {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, secret)
+	// Longest first so a secret containing another one is fully masked
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+// AddSecrets registers every secret value of the configuration
+func (r *Redactor) AddSecrets(c Configuration) {
+	r.AddSecret(c.ApiToken)
+	for _, group := range c.Requests {
+		r.AddSecret(group.Credentials.Password)
+		if group.Auth != nil {
+			r.AddSecret(group.Auth.Password)
+			r.AddSecret(group.Auth.Token)
+			r.AddSecret(group.Auth.ClientSecret)
+		}
+	}
+}
+
+func (r *Redactor) String(s string) string {
+	s = urlPattern.ReplaceAllStringFunc(s, r.URL)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+
+	return s
+}
+
+// URL masks the userinfo password, webhook tokens and the sensitive query
+// params, the rest of the url is left untouched. The masks are applied to
+// rawUrl itself, rebuilding it from the parsed url would escape templates such
+// as {{.Port}}.
+func (r *Redactor) URL(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+
+	redacted := redactWebhookPath(rawUrl)
+	if _, ok := u.User.Password(); ok {
+		start := strings.Index(redacted, "://") + len("://")
+		authority := redacted[start:]
+		if end := strings.IndexAny(authority, "/?#"); end >= 0 {
+			authority = authority[:end]
+		}
+		at := start + strings.LastIndex(authority, "@")
+		username, _, _ := strings.Cut(redacted[start:at], ":")
+		redacted = redacted[:start] + username + ":" + Redacted + redacted[at:]
+	}
+
+	if u.RawQuery != "" {
+		rest, fragment, hasFragment := strings.Cut(redacted, "#")
+		base, query, _ := strings.Cut(rest, "?")
+
+		r.mu.RLock()
+		params := strings.Split(query, "&")
+		for i, param := range params {
+			key, _, found := strings.Cut(param, "=")
+			name, err := url.QueryUnescape(key)
+			if err != nil {
+				name = key
+			}
+			if _, ok := r.params[strings.ToLower(name)]; found && ok {
+				params[i] = key + "=" + Redacted
+			}
+		}
+		r.mu.RUnlock()
+
+		redacted = base + "?" + strings.Join(params, "&")
+		if hasFragment {
+			redacted += "#" + fragment
+		}
+	}
+
+	return redacted
+}
+
+func redactWebhookPath(rawUrl string) string {
+	start := strings.Index(rawUrl, "://")
+	if start < 0 {
+		return rawUrl
+	}
+	start += len("://")
+	pathStart := strings.IndexAny(rawUrl[start:], "/?#")
+	if pathStart < 0 || rawUrl[start+pathStart] != '/' {
+		return rawUrl
+	}
+	pathStart += start
+	pathEnd := len(rawUrl)
+	if end := strings.IndexAny(rawUrl[pathStart:], "?#"); end >= 0 {
+		pathEnd = pathStart + end
+	}
+
+	path := rawUrl[pathStart:pathEnd]
+	for _, prefix := range webhookPaths {
+		if i := strings.Index(path, prefix); i >= 0 && i+len(prefix) < len(path) {
+			return rawUrl[:pathStart+i+len(prefix)] + Redacted + rawUrl[pathEnd:]
+		}
+	}
+	return rawUrl
+}
+
+// Header returns a copy of the headers with credentials and cookies masked
+func (r *Redactor) Header(header http.Header) http.Header {
+	redacted := http.Header{}
+	for key, values := range header {
+		redacted[key] = make([]string, len(values))
+		for i, value := range values {
+			redacted[key][i] = r.String(value)
+		}
+	}
+
+	for _, key := range sensitiveHeaders {
+		values := redacted[http.CanonicalHeaderKey(key)]
+		for i, value := range values {
+			// Keep the scheme, it is useful to know which auth was used
+			if scheme, _, found := strings.Cut(value, " "); found && key != "Cookie" && key != "Set-Cookie" {
+				values[i] = scheme + " " + Redacted
+			} else {
+				values[i] = Redacted
+			}
+		}
+	}
+
+	return redacted
+}
+
+func AddSecret(secret string) {
+	redactor.AddSecret(secret)
+}
+
+func AddSecrets(c Configuration) {
+	redactor.AddSecrets(c)
+}
+
+func SetSensitiveParams(params []string) {
+	redactor.SetSensitiveParams(params)
+}
+
+func Redact(s string) string {
+	return redactor.String(s)
+}
+
+func RedactURL(rawUrl string) string {
+	return redactor.URL(rawUrl)
+}
+
+func RedactHeader(header http.Header) http.Header {
+	return redactor.Header(header)
+}
@@ -0,0 +1,134 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// RenderedRequest is a request as it would be sent, secrets are not redacted
+type RenderedRequest struct {
+	Service string
+	Step    int
+	Method  string
+	Url     string
+	Header  http.Header
+	Body    string
+}
+
+// Auth schemes that need a round trip to the server can't be rendered, a
+// placeholder is used instead
+var authPlaceholders = map[string]string{
+	AuthDigest: "Digest <response to the server challenge>",
+	AuthOAuth2: "Bearer <token from the token url>",
+}
+
+// RenderRequests builds every request of every group without sending them.
+// Rendering continues after an error so all the broken templates are reported.
+func RenderRequests(port uint16, requests map[string]RequestGroup) ([]RenderedRequest, error) {
+	rendered := []RenderedRequest{}
+	errs := RequesterError{}
+
+	services := make([]string, 0, len(requests))
+	for service := range requests {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	for _, service := range services {
+		requestGroup := requests[service]
+		credentials, err := requestGroup.Credentials.resolve()
+		if err != nil {
+			errs.Errors = append(errs.Errors, fmt.Errorf("%s: couldn't read credentials %w", service, err))
+			continue
+		}
+		templateData := templateData{Credentials: credentials, Port: port}
+
+		auth, err := renderAuth(requestGroup.Auth, credentials)
+		if err != nil {
+			errs.Errors = append(errs.Errors, fmt.Errorf("%s: couldn't setup authentication %w", service, err))
+			continue
+		}
+
+		// The check is step 0 and the verification the last one, as when sent
+		requests := requestGroup.Requests
+		first := 1
+		if requestGroup.Check != nil {
+			requests = append([]Request{requestGroup.Check.request()}, requests...)
+			first = 0
+		}
+		if requestGroup.Verify != nil {
+			requests = append(requests, requestGroup.Verify.request())
+		}
+
+		for k, request := range requests {
+			req, err := renderRequest(service, first+k, request, templateData, auth)
+			if err != nil {
+				errs.Errors = append(errs.Errors, fmt.Errorf("%s step %d: %w", service, first+k, err))
+				continue
+			}
+			rendered = append(rendered, req)
+		}
+	}
+
+	if len(errs.Errors) == 0 {
+		return rendered, nil
+	}
+
+	return rendered, &errs
+}
+
+func renderRequest(service string, step int, request Request, templateData templateData, auth func(req *http.Request)) (RenderedRequest, error) {
+	url, err := withUrl(request.Url, templateData)
+	if err != nil {
+		return RenderedRequest{}, err
+	}
+
+	bodyInfo, err := withBody(request, templateData)
+	if err != nil {
+		return RenderedRequest{}, err
+	}
+
+	req, err := newHttpRequest(withMethod(request.Method), url, bodyInfo, http.Header{})
+	if err != nil {
+		return RenderedRequest{}, err
+	}
+	if auth != nil {
+		auth(req)
+	}
+
+	body := ""
+	if bodyInfo.Data != nil {
+		body = bodyInfo.Data.String()
+	}
+	return RenderedRequest{
+		Service: service,
+		Step:    step,
+		Method:  req.Method,
+		Url:     url,
+		Header:  req.Header,
+		Body:    body,
+	}, nil
+}
+
+func renderAuth(auth *Auth, credentials Credentials) (func(req *http.Request), error) {
+	if auth == nil {
+		return nil, nil
+	}
+
+	if placeholder, ok := authPlaceholders[auth.Type]; ok {
+		return func(req *http.Request) {
+			req.Header.Set("Authorization", placeholder)
+		}, nil
+	}
+
+	authenticator, err := newAuthenticator(auth, credentials, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(req *http.Request) {
+		authenticator.authorize(req)
+	}, nil
+}
@@ -0,0 +1,173 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"log/slog"
+	"net/url"
+	"sort"
+)
+
+// Reporter is told about the progress of a sync. The requester calls it
+// synchronously so implementations should return quickly.
+type Reporter interface {
+	SyncStarted(run SyncRun, groups []string)
+	GroupStarted(group string)
+	StepFinished(update StatusUpdate)
+	// GroupFinished is called with the error that stopped the group, if any
+	GroupFinished(group string, err error)
+	SyncFinished(err error)
+}
+
+// MultiReporter reports to every one of its reporters in order
+type MultiReporter []Reporter
+
+func (m MultiReporter) SyncStarted(run SyncRun, groups []string) {
+	for _, r := range m {
+		r.SyncStarted(run, groups)
+	}
+}
+
+func (m MultiReporter) GroupStarted(group string) {
+	for _, r := range m {
+		r.GroupStarted(group)
+	}
+}
+
+func (m MultiReporter) StepFinished(update StatusUpdate) {
+	for _, r := range m {
+		r.StepFinished(update)
+	}
+}
+
+func (m MultiReporter) GroupFinished(group string, err error) {
+	for _, r := range m {
+		r.GroupFinished(group, err)
+	}
+}
+
+func (m MultiReporter) SyncFinished(err error) {
+	for _, r := range m {
+		r.SyncFinished(err)
+	}
+}
+
+// NopReporter ignores everything, it is used when no reporter is given
+type NopReporter struct{}
+
+func (NopReporter) SyncStarted(run SyncRun, groups []string) {}
+func (NopReporter) GroupStarted(group string)                {}
+func (NopReporter) StepFinished(update StatusUpdate)         {}
+func (NopReporter) GroupFinished(group string, err error)    {}
+func (NopReporter) SyncFinished(err error)                   {}
+
+// LogReporter logs the progress through logger
+type LogReporter struct {
+	logger *slog.Logger
+	run    SyncRun
+	failed int
+}
+
+func NewLogReporter(logger *slog.Logger) *LogReporter {
+	return &LogReporter{logger: logger}
+}
+
+func (r *LogReporter) SyncStarted(run SyncRun, groups []string) {
+	r.run = run
+	r.failed = 0
+	r.logger.Info("synchronizing port", r.runAttrs("groups", len(groups))...)
+}
+
+func (r *LogReporter) runAttrs(attrs ...any) []any {
+	runAttrs := []any{"port", r.run.Port, "run", r.run.ID}
+	if r.run.Attempt > 1 {
+		runAttrs = append(runAttrs, "attempt", r.run.Attempt)
+	}
+	return append(runAttrs, attrs...)
+}
+
+func (r *LogReporter) GroupStarted(group string) {
+	r.logger.Debug("synchronizing group", r.runAttrs("group", group)...)
+}
+
+func (r *LogReporter) StepFinished(update StatusUpdate) {
+	attrs := r.runAttrs("group", update.Service, "step", update.Step)
+	if update.Url != "" {
+		attrs = append(attrs, "method", update.Method, "url", update.Url)
+	} else if update.Path != "" {
+		attrs = append(attrs, "method", update.Method, "url", update.Path)
+	}
+	if update.StatusCode != 0 {
+		attrs = append(attrs, "status", update.StatusCode, "size", update.ResponseSize)
+	}
+	if update.Duration != 0 {
+		attrs = append(attrs, "duration", update.Duration)
+	}
+
+	switch update.Status {
+	case Error:
+		r.logger.Error("request failed", append(attrs, "error", requesterError(update.Error))...)
+	case InSync:
+		r.logger.Info("already in sync", attrs...)
+	default:
+		r.logger.Info("request succeeded", attrs...)
+	}
+}
+
+func (r *LogReporter) GroupFinished(group string, err error) {
+	if err != nil {
+		r.failed++
+	}
+}
+
+func (r *LogReporter) SyncFinished(err error) {
+	if r.failed > 0 {
+		r.logger.Warn("synchronization failed", r.runAttrs("failed-groups", r.failed)...)
+		return
+	}
+	r.logger.Debug("synchronization finished", r.runAttrs()...)
+}
+
+// requesterError drops the method and url the http client adds to its errors,
+// they are already logged
+func requesterError(err error) error {
+	if err, ok := err.(*url.Error); ok {
+		return err.Err
+	}
+	return err
+}
+
+// ResultReporter collects the result of a sync
+type ResultReporter struct {
+	run     SyncRun
+	updates []StatusUpdate
+}
+
+func (r *ResultReporter) SyncStarted(run SyncRun, groups []string) {
+	r.run = run
+	r.updates = []StatusUpdate{}
+}
+
+func (r *ResultReporter) GroupStarted(group string) {}
+
+func (r *ResultReporter) StepFinished(update StatusUpdate) {
+	r.updates = append(r.updates, update)
+}
+
+func (r *ResultReporter) GroupFinished(group string, err error) {}
+
+func (r *ResultReporter) SyncFinished(err error) {}
+
+// Result returns the result of the last sync
+func (r *ResultReporter) Result() SyncResult {
+	return NewSyncResult(r.run, r.updates)
+}
+
+// GroupNames returns the names of the groups sorted
+func GroupNames(requests map[string]RequestGroup) []string {
+	names := make([]string, 0, len(requests))
+	for name := range requests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,71 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type recordingReporter struct {
+	events []string
+}
+
+func (r *recordingReporter) SyncStarted(run SyncRun, groups []string) {
+	r.events = append(r.events, fmt.Sprintf("sync started %d %v", run.Port, groups))
+}
+
+func (r *recordingReporter) GroupStarted(group string) {
+	r.events = append(r.events, "group started "+group)
+}
+
+func (r *recordingReporter) StepFinished(update StatusUpdate) {
+	r.events = append(r.events, fmt.Sprintf("step finished %s %d %d", update.Service, update.Step, update.Status))
+}
+
+func (r *recordingReporter) GroupFinished(group string, err error) {
+	r.events = append(r.events, fmt.Sprintf("group finished %s %t", group, err == nil))
+}
+
+func (r *recordingReporter) SyncFinished(err error) {
+	r.events = append(r.events, fmt.Sprintf("sync finished %t", err == nil))
+}
+
+func TestReporter(t *testing.T) {
+	client := &http.Client{}
+	requester := NewRequesterWithClient(client)
+	client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "fail.com" {
+			return &http.Response{StatusCode: 500}, nil
+		}
+		return &http.Response{StatusCode: 200}, nil
+	})
+
+	first, second := &recordingReporter{}, &recordingReporter{}
+	err := requester.SendRequests(1337, map[string]RequestGroup{
+		"b": {Requests: []Request{{Url: "http://fail.com"}, {Url: "http://f.com/never"}}},
+		"a": {Requests: []Request{{Url: "http://f.com"}, {Url: "http://f.com/2"}}},
+	}, MultiReporter{first, second})
+	if err == nil {
+		t.Fatal("expected group b to fail")
+	}
+
+	expected := []string{
+		"sync started 1337 [a b]",
+		"group started a",
+		"step finished a 1 1",
+		"step finished a 2 1",
+		"group finished a true",
+		"group started b",
+		"step finished b 1 2",
+		"group finished b false",
+		"sync finished false",
+	}
+	if !reflect.DeepEqual(first.events, expected) {
+		t.Fatalf("unexpected events\n%v\n%v", first.events, expected)
+	}
+	if !reflect.DeepEqual(first.events, second.events) {
+		t.Fatal("every reporter should get the same events")
+	}
+}
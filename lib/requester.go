@@ -5,19 +5,28 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
 const (
 	UnInitialized = 0
 	Success       = 1
 	Error         = 2
+	// InSync is a check that read the expected port, the rest of the group
+	// is skipped
+	InSync = 3
 )
 
-var forwardHeaders = []string{"Authorization"}
+var forwardHeaders = []string{"Authorization", "X-Transmission-Session-Id"}
+
+// Only this much of a response body is kept, the rest is just counted
+const maxBodySize = 1 << 20
 
 type RequesterError struct {
 	Errors []error
@@ -31,34 +40,71 @@ func (m *RequesterError) Error() string {
 	return strings.Join(errMsgs, "; ")
 }
 
+// StatusUpdate is the outcome of a step. Path is the url template and Url the
+// rendered one with its secrets redacted.
 type StatusUpdate struct {
-	Service string
-	Method  string
-	Path    string
-	Status  int
-	Step    int
-	Error   error
+	Service      string
+	Method       string
+	Path         string
+	Url          string
+	Status       int
+	Step         int
+	StatusCode   int
+	ResponseSize int64
+	Duration     time.Duration
+	Error        error
+	Port         uint16
+	RunID        string
+	Attempt      int
+}
+
+// SyncRun identifies a sync of a port, retries of the same sync keep the ID
+// and increase Attempt. PreviousPort is the port replaced by the change that
+// started the run, 0 for runs that didn't change the port.
+type SyncRun struct {
+	ID           string
+	Port         uint16
+	PreviousPort uint16
+	Attempt      int
+}
+
+func NewSyncRun(port uint16) SyncRun {
+	id, err := randomHex(8)
+	if err != nil {
+		id = strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return SyncRun{ID: id, Port: port, Attempt: 1}
 }
 
 type Requester struct {
 	httpClient *http.Client
+	tokens     *tokenCache
 }
 
+// requestTimeout bounds every request so a service that doesn't answer can't
+// block the watch loop
+const requestTimeout = 30 * time.Second
+
 func NewRequester() Requester {
-	return Requester{httpClient: http.DefaultClient}
+	return NewRequesterWithClient(&http.Client{Timeout: requestTimeout})
 }
 
 func NewRequesterWithClient(client *http.Client) Requester {
-	return Requester{httpClient: client}
+	return Requester{httpClient: client, tokens: newTokenCache()}
 }
 
+// templateData is what templates can use, Error and FailedStep are only set
+// for the on-failure requests
 type templateData struct {
 	Credentials
-	Port uint16
+	Port         uint16
+	PreviousPort uint16
+	Error        string
+	FailedStep   int
 }
 
 func executeTemplate(templateStr string, templateData templateData) (*bytes.Buffer, error) {
-	templ, err := template.New("template").Parse(templateStr)
+	templ, err := template.New("template").Funcs(templateFuncs).Parse(templateStr)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't parse template in url %w", err)
 	}
@@ -82,7 +128,7 @@ func withMethod(method string) string {
 func withUrl(urlTempl string, templateData templateData) (string, error) {
 	url, err := executeTemplate(urlTempl, templateData)
 	if err != nil {
-		err := fmt.Errorf("coudln't execute template for url %s error: %v", url, err)
+		err := fmt.Errorf("coudln't execute template for url %s error: %v", urlTempl, err)
 		return "", err
 	}
 
@@ -113,83 +159,279 @@ func withBody(request Request, templateData templateData) (bodyInfo, error) {
 	return bodyInfo, nil
 }
 
-func reportUpdate(updateChan chan StatusUpdate, update StatusUpdate) {
-	if updateChan == nil {
-		return
+func newHttpRequest(method string, url string, bodyInfo bodyInfo, headers http.Header) (*http.Request, error) {
+	var body io.Reader
+	if bodyInfo.Data != nil {
+		body = bytes.NewReader(bodyInfo.Data.Bytes())
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = headers.Clone()
+	if bodyInfo.ContentType != "" {
+		req.Header.Set("Content-Type", bodyInfo.ContentType)
+	}
+
+	return req, nil
+}
+
+// forwardResponseHeaders copies the forwarded headers of the response into
+// headers, it returns true if any of them changed
+func forwardResponseHeaders(resp *http.Response, headers http.Header, auth authenticator) bool {
+	changed := false
+	for _, header := range forwardHeaders {
+		// The configured auth owns the Authorization header
+		if auth != nil && header == "Authorization" {
+			continue
+		}
+		if value := resp.Header.Get(header); value != "" && value != headers.Get(header) {
+			headers[header] = []string{value}
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// do sends the request authorizing it first, if the server answers with a
+// challenge the authenticator can handle the request is rebuilt and sent again.
+// A 409 carrying a new forwarded header, like the Transmission session id, is
+// retried too with the new header.
+func (r *Requester) do(method string, url string, bodyInfo bodyInfo, headers http.Header, auth authenticator) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		req, err := newHttpRequest(method, url, bodyInfo, headers)
+		if err != nil {
+			return nil, err
+		}
+		if auth != nil {
+			if err := auth.authorize(req); err != nil {
+				return nil, fmt.Errorf("couldn't authorize request %w", err)
+			}
+		}
+		return r.httpClient.Do(req)
+	}
+
+	resp, err := send()
+	if err != nil {
+		return nil, err
+	}
+
+	retry := false
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		retry = auth != nil && auth.challenge(resp)
+	case http.StatusConflict:
+		retry = forwardResponseHeaders(resp, headers, auth)
+	}
+	if !retry {
+		return resp, nil
+	}
+	slog.Debug("retrying request", "url", url, "status", resp.StatusCode)
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return send()
+}
+
+// send sends request filling update with how it went and returns the response
+// body, anything but a 200 or a failed assertion is an error
+func (r *Requester) send(request Request, templateData templateData, headers http.Header, auth authenticator, update *StatusUpdate) ([]byte, error) {
+	url, err := withUrl(request.Url, templateData)
+	if err != nil {
+		return nil, fmt.Errorf("cound't build url with template %w", err)
+	}
+	update.Url = RedactURL(Redact(url))
+
+	bodyInfo, err := withBody(request, templateData)
+	if err != nil {
+		return nil, fmt.Errorf("content type was set but no payload found %s", request.ContentType)
+	}
+
+	start := time.Now()
+	resp, err := r.do(withMethod(request.Method), url, bodyInfo, headers, auth)
+	update.Duration = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	discarded, _ := io.Copy(io.Discard, resp.Body)
+	update.ResponseSize = int64(len(body)) + discarded
+	update.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		return body, fmt.Errorf("http request response code is not 200 but %d instead", resp.StatusCode)
+	}
+	if err := checkAssertions(request.Assert, resp.Header, body, templateData); err != nil {
+		return body, err
 	}
-	updateChan <- update
+	forwardResponseHeaders(resp, headers, auth)
+
+	return body, nil
 }
 
-func (r *Requester) SendRequests(port uint16, requests map[string]RequestGroup, updateChan chan StatusUpdate) error {
+// probe sends p and returns the value it reads and the one it expects
+func (r *Requester) probe(p Probe, templateData templateData, headers http.Header, auth authenticator, update *StatusUpdate) (string, string, error) {
+	body, err := r.send(p.request(), templateData, headers, auth, update)
+	if err != nil {
+		return "", "", err
+	}
+
+	value, err := p.Extract(body)
+	if err != nil {
+		return "", "", err
+	}
+	expected, err := p.expected(templateData)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't build the expected value %w", err)
+	}
+
+	return value, expected, nil
+}
+
+// inSync sends the check of a group as step 0 and returns whether it reads the
+// expected value, the step is reported as InSync then. A check that fails
+// doesn't fail the group, the port is pushed anyway.
+func (r *Requester) inSync(service string, check Probe, run SyncRun, templateData templateData, headers http.Header, auth authenticator, reporter Reporter) bool {
+	update := StatusUpdate{
+		Service: service, Method: withMethod(check.Method), Path: check.Url, Step: 0, Status: UnInitialized,
+		Port: run.Port, RunID: run.ID, Attempt: run.Attempt,
+	}
+	value, expected, err := r.probe(check, templateData, headers, auth, &update)
+	if err != nil {
+		slog.Warn("couldn't check the port, applying it anyway", "group", service, "error", Redact(err.Error()))
+		return false
+	}
+
+	if value != expected {
+		update.Status = Success
+		reporter.StepFinished(update)
+		slog.Debug("the check read a different value, applying the port", "group", service, "found", value, "expected", expected)
+		return false
+	}
+
+	update.Status = InSync
+	reporter.StepFinished(update)
+	return true
+}
+
+// onFailure sends the on-failure requests of a group in its session. They are
+// only logged, the group failed already and a failure here stops the rest.
+func (r *Requester) onFailure(service string, requests []Request, templateData templateData, headers http.Header, auth authenticator) {
+	for k, request := range requests {
+		update := StatusUpdate{Service: service, Method: withMethod(request.Method), Path: request.Url}
+		if _, err := r.send(request, templateData, headers, auth, &update); err != nil {
+			slog.Warn("on-failure request failed", "group", service, "request", k+1, "error", Redact(err.Error()))
+			return
+		}
+		slog.Info("on-failure request succeeded", "group", service, "request", k+1)
+	}
+}
+
+// SendRequests sends the requests of every group with port in a new run
+func (r *Requester) SendRequests(port uint16, requests map[string]RequestGroup, reporter Reporter) error {
+	return r.Sync(NewSyncRun(port), requests, reporter)
+}
+
+// Sync sends the requests of every group with the port of run, the progress is
+// reported to reporter which can be nil
+func (r *Requester) Sync(run SyncRun, requests map[string]RequestGroup, reporter Reporter) error {
+	port := run.Port
+	if reporter == nil {
+		reporter = NopReporter{}
+	}
 	errs := RequesterError{}
 
+	var groupErr error
+	var failedStep int
 	addErr := func(err error, update StatusUpdate) {
 		update.Error = err
 		update.Status = Error
-		reportUpdate(updateChan, update)
+		reporter.StepFinished(update)
 		errs.Errors = append(errs.Errors, err)
+		groupErr = err
+		failedStep = update.Step
 	}
 
-	for service, requestGroup := range requests {
-		headers := map[string][]string{}
+	names := GroupNames(requests)
+	reporter.SyncStarted(run, names)
+	for _, service := range names {
+		requestGroup := requests[service]
+		groupErr = nil
+		reporter.GroupStarted(service)
+
+		headers := http.Header{}
 		jar, _ := cookiejar.New(nil)
 		r.httpClient.Jar = jar
-		templateData := templateData{requestGroup.Credentials, port}
+		credentials, err := requestGroup.Credentials.resolve()
+		if err != nil {
+			update := StatusUpdate{Service: service, Step: 1, Status: UnInitialized, Port: port, RunID: run.ID, Attempt: run.Attempt}
+			addErr(fmt.Errorf("couldn't read credentials %w", err), update)
+			reporter.GroupFinished(service, groupErr)
+			continue
+		}
+		templateData := templateData{Credentials: credentials, Port: port, PreviousPort: run.PreviousPort}
+
+		auth, err := newAuthenticator(requestGroup.Auth, credentials, r)
+		if err != nil {
+			update := StatusUpdate{Service: service, Step: 1, Status: UnInitialized, Port: port, RunID: run.ID, Attempt: run.Attempt}
+			addErr(fmt.Errorf("couldn't setup authentication %w", err), update)
+			reporter.GroupFinished(service, groupErr)
+			continue
+		}
+
+		if requestGroup.Check != nil && r.inSync(service, *requestGroup.Check, run, templateData, headers, auth, reporter) {
+			reporter.GroupFinished(service, nil)
+			continue
+		}
 
 		for k, request := range requestGroup.Requests {
-			update := StatusUpdate{Service: service, Method: request.Method, Path: request.Url, Step: k + 1, Status: UnInitialized}
-			url, err := withUrl(request.Url, templateData)
-			if err != nil {
-				err = fmt.Errorf("cound't build url with template %w", err)
-				addErr(err, update)
-				break
+			update := StatusUpdate{
+				Service: service, Method: withMethod(request.Method), Path: request.Url, Step: k + 1, Status: UnInitialized,
+				Port: port, RunID: run.ID, Attempt: run.Attempt,
 			}
-
-			bodyInfo, err := withBody(request, templateData)
-			if err != nil {
-				err = fmt.Errorf("content type was set but no payload found %s", request.ContentType)
+			if _, err := r.send(request, templateData, headers, auth, &update); err != nil {
 				addErr(err, update)
 				break
 			}
 
-			var body io.Reader
-			if bodyInfo.Data != nil {
-				body = bodyInfo.Data
-			}
-
-			req, _ := http.NewRequest(withMethod(request.Method), url, body)
-			req.Header = http.Header(headers)
-			if bodyInfo.ContentType != "" {
-				req.Header.Set("Content-Type", bodyInfo.ContentType)
-			}
+			update.Status = Success
+			reporter.StepFinished(update)
+		}
 
-			r, err := r.httpClient.Do(req)
-			if err != nil {
-				addErr(err, update)
-				break
+		if verify := requestGroup.Verify; verify != nil && groupErr == nil {
+			update := StatusUpdate{
+				Service: service, Method: withMethod(verify.Method), Path: verify.Url, Step: len(requestGroup.Requests) + 1,
+				Status: UnInitialized, Port: port, RunID: run.ID, Attempt: run.Attempt,
 			}
-			if r.StatusCode != http.StatusOK {
-				addErr(fmt.Errorf("http request response code is not 200 but %d instead", r.StatusCode), update)
-				break
+			value, expected, err := r.probe(*verify, templateData, headers, auth, &update)
+			if err == nil && value != expected {
+				err = fmt.Errorf("expected %s but read %s", expected, value)
 			}
-
-			update.Status = Success
-			reportUpdate(updateChan, update)
-			for _, header := range forwardHeaders {
-				if auth := r.Header.Get(header); auth != "" {
-					headers[header] = []string{auth}
-				}
+			if err != nil {
+				addErr(fmt.Errorf("couldn't verify the port %w", err), update)
+			} else {
+				update.Status = Success
+				reporter.StepFinished(update)
 			}
 		}
-	}
 
-	if updateChan != nil {
-		close(updateChan)
+		if groupErr != nil && len(requestGroup.OnFailure) > 0 {
+			templateData.Error = Redact(groupErr.Error())
+			templateData.FailedStep = failedStep
+			r.onFailure(service, requestGroup.OnFailure, templateData, headers, auth)
+		}
+		reporter.GroupFinished(service, groupErr)
 	}
 
 	if len(errs.Errors) == 0 {
+		reporter.SyncFinished(nil)
 		return nil
 	}
 
+	reporter.SyncFinished(&errs)
 	return &errs
 }
@@ -4,8 +4,10 @@ package lib
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/cookiejar"
+	"strings"
 	"testing"
 )
 
@@ -246,6 +248,34 @@ func TestSimpleRequest(t *testing.T) {
 		}
 	})
 
+	t.Run("Session header on conflict", func(t *testing.T) {
+		totalRequests := 0
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			totalRequests += 1
+
+			r := http.Response{StatusCode: 200, Header: http.Header{}}
+			if req.Header.Get("X-Transmission-Session-Id") != "some-session" {
+				r.StatusCode = 409
+				r.Header.Set("X-Transmission-Session-Id", "some-session")
+			}
+
+			return &r, nil
+		})
+
+		errs := requester.SendRequests(port, map[string]RequestGroup{
+			"test": {
+				Requests: []Request{{Url: "http://f.com/rpc"}, {Url: "http://f.com/rpc"}}},
+		}, nil)
+
+		if errs != nil {
+			t.Fatal("SendRequests failed with some errors", errs)
+		}
+
+		if totalRequests != 3 {
+			t.Fatalf("expected 3 request but %d received", totalRequests)
+		}
+	})
+
 	t.Run("Stop group processing on error", func(t *testing.T) {
 		totalRequests := 0
 		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
@@ -273,4 +303,172 @@ func TestSimpleRequest(t *testing.T) {
 			t.Fatalf("expected 3 request but %d received", totalRequests)
 		}
 	})
+
+	t.Run("Status updates", func(t *testing.T) {
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			body := io.NopCloser(strings.NewReader("internal error"))
+			return &http.Response{StatusCode: 500, Header: http.Header{}, Body: body}, nil
+		})
+
+		reporter := &ResultReporter{}
+		run := SyncRun{ID: "run1", Port: port, Attempt: 2}
+		requester.Sync(run, map[string]RequestGroup{
+			"test": {Requests: []Request{{Url: "http://f.com/{{.Port}}?token=abc"}}},
+		}, reporter)
+
+		if len(reporter.updates) != 1 {
+			t.Fatalf("expected 1 update but %d reported", len(reporter.updates))
+		}
+		update := reporter.updates[0]
+		if update.Status != Error || update.StatusCode != 500 || update.Duration <= 0 || update.ResponseSize != 14 {
+			t.Fatalf("unexpected update %+v", update)
+		}
+		if update.Url != fmt.Sprintf("http://f.com/%d?token=****", port) || update.Method != "GET" {
+			t.Fatalf("unexpected rendered url %s %s", update.Method, update.Url)
+		}
+		if update.Port != port || update.RunID != "run1" || update.Attempt != 2 {
+			t.Fatalf("update doesn't carry the run %+v", update)
+		}
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		applied := "1337"
+		sent := 0
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/preferences" {
+				body := io.NopCloser(strings.NewReader(`{"listen_port": ` + applied + `}`))
+				return &http.Response{StatusCode: 200, Header: http.Header{}, Body: body}, nil
+			}
+			sent++
+			return &http.Response{StatusCode: 200, Header: http.Header{}}, nil
+		})
+
+		groups := map[string]RequestGroup{
+			"test": {
+				Requests: []Request{{Url: "http://f.com/setPreferences"}},
+				Check:    &Probe{Url: "http://f.com/preferences", JsonPath: "listen_port"},
+			},
+		}
+		run := SyncRun{ID: "run", Port: port, Attempt: 1}
+
+		reporter := &ResultReporter{}
+		if err := requester.Sync(run, groups, reporter); err != nil || sent != 0 {
+			t.Fatalf("the port was already applied %v", err)
+		}
+		result := reporter.Result()
+		if !result.Success || !result.Groups[0].InSync || len(result.Groups[0].Steps) != 1 || !result.Groups[0].Steps[0].InSync {
+			t.Fatalf("expected the group to be reported in sync %+v", result)
+		}
+
+		applied = "4242"
+		reporter = &ResultReporter{}
+		requester.Sync(run, groups, reporter)
+		if sent != 1 {
+			t.Fatal("the port drifted and must be applied again")
+		}
+		if result := reporter.Result(); !result.Success || result.Groups[0].InSync {
+			t.Fatalf("expected the group to be applied %+v", result)
+		}
+	})
+
+	t.Run("Assertions", func(t *testing.T) {
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			body := io.NopCloser(strings.NewReader("Fails."))
+			return &http.Response{StatusCode: 200, Header: http.Header{}, Body: body}, nil
+		})
+
+		reporter := &ResultReporter{}
+		err := requester.Sync(SyncRun{Port: port, Attempt: 1}, map[string]RequestGroup{
+			"test": {Requests: []Request{
+				{Url: "http://f.com/login", Assert: []Assertion{{BodyNotContains: "Fails."}}},
+				{Url: "http://f.com/set"},
+			}},
+		}, reporter)
+
+		steps := reporter.Result().Groups[0].Steps
+		if err == nil || len(steps) != 1 || steps[0].StatusCode != 200 || steps[0].Error != `response contains "Fails.": Fails.` {
+			t.Fatalf("expected the assertion to fail the group %+v", steps)
+		}
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		applied := ""
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/set" {
+				applied = req.URL.Query().Get("port")
+			}
+			body := io.NopCloser(strings.NewReader("port=" + applied))
+			return &http.Response{StatusCode: 200, Header: http.Header{}, Body: body}, nil
+		})
+
+		groups := map[string]RequestGroup{
+			"test": {
+				Requests: []Request{{Url: "http://f.com/set?port={{.Port}}"}},
+				Verify:   &Probe{Url: "http://f.com/get", Regex: `port=(\d+)`},
+			},
+		}
+		reporter := &ResultReporter{}
+		if err := requester.Sync(SyncRun{Port: port, Attempt: 1}, groups, reporter); err != nil {
+			t.Fatalf("the port was applied %v", err)
+		}
+		if steps := reporter.Result().Groups[0].Steps; len(steps) != 2 || steps[1].Step != 2 || !steps[1].Success {
+			t.Fatalf("expected the verification as the last step %+v", steps)
+		}
+
+		groups["test"].Verify.Expected = "{{.Port}}0"
+		reporter = &ResultReporter{}
+		err := requester.Sync(SyncRun{Port: port, Attempt: 1}, groups, reporter)
+		expected := fmt.Sprintf("couldn't verify the port expected %d0 but read %d", port, port)
+		if err == nil || reporter.Result().Groups[0].Steps[1].Error != expected {
+			t.Fatalf("expected the verification to fail %+v", reporter.Result())
+		}
+	})
+
+	t.Run("On failure", func(t *testing.T) {
+		sent := []string{}
+		client.Transport = MockTransport(func(req *http.Request) (*http.Response, error) {
+			sent = append(sent, req.URL.String())
+			if req.URL.Path == "/forward" {
+				return &http.Response{StatusCode: 500, Header: http.Header{}}, nil
+			}
+			return &http.Response{StatusCode: 200, Header: http.Header{}}, nil
+		})
+
+		groups := map[string]RequestGroup{
+			"test": {
+				Requests: []Request{{Url: "http://f.com/set?port={{.Port}}"}, {Url: "http://f.com/forward"}},
+				OnFailure: []Request{
+					{Url: "http://f.com/set?port={{.PreviousPort}}&step={{.FailedStep}}&error={{urlquery .Error}}"},
+				},
+			},
+		}
+		run := SyncRun{Port: port, PreviousPort: 4242, Attempt: 1}
+		reporter := &ResultReporter{}
+		if err := requester.Sync(run, groups, reporter); err == nil {
+			t.Fatal("the group must still fail")
+		}
+		if len(sent) != 3 {
+			t.Fatalf("expected the on-failure request after the failure %v", sent)
+		}
+		expected := "http://f.com/set?port=4242&step=2&error=http+request+response+code+is+not+200+but+500+instead"
+		if sent[2] != expected {
+			t.Fatalf("expected %s but got %s", expected, sent[2])
+		}
+		if steps := reporter.Result().Groups[0].Steps; len(steps) != 2 {
+			t.Fatalf("the on-failure requests aren't steps of the group %+v", steps)
+		}
+
+		sent = []string{}
+		groups["test"].OnFailure[0].Url = "http://f.com/{{if .PreviousPort}}set?port={{.PreviousPort}}{{else}}logout{{end}}"
+		resync := SyncRun{Port: port, Attempt: 1}
+		if err := requester.Sync(resync, groups, nil); err == nil || len(sent) != 3 || sent[2] != "http://f.com/logout" {
+			t.Fatalf("expected the on-failure request without a previous port %v", sent)
+		}
+
+		sent = []string{}
+		groups["test"].Requests[1].Url = "http://f.com/ok"
+		if err := requester.Sync(run, groups, nil); err != nil || len(sent) != 2 {
+			t.Fatalf("nothing is rolled back when the group succeeds %v %v", err, sent)
+		}
+	})
 }
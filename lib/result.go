@@ -0,0 +1,91 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import "sort"
+
+// StepResult is the outcome of one request of a group
+type StepResult struct {
+	Step         int     `json:"step"`
+	Method       string  `json:"method,omitempty"`
+	Url          string  `json:"url,omitempty"`
+	Success      bool    `json:"success"`
+	InSync       bool    `json:"in_sync,omitempty"`
+	StatusCode   int     `json:"status,omitempty"`
+	ResponseSize int64   `json:"response_size,omitempty"`
+	DurationMs   float64 `json:"duration_ms,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// GroupResult is the outcome of a group, a group fails as soon as one of its
+// steps does. InSync groups already had the port and were skipped.
+type GroupResult struct {
+	Group   string       `json:"group"`
+	Success bool         `json:"success"`
+	InSync  bool         `json:"in_sync,omitempty"`
+	Steps   []StepResult `json:"steps"`
+}
+
+// SyncResult is the outcome of synchronizing a port once
+type SyncResult struct {
+	Port    uint16        `json:"port,omitempty"`
+	RunID   string        `json:"run_id,omitempty"`
+	Attempt int           `json:"attempt,omitempty"`
+	Success bool          `json:"success"`
+	Error   string        `json:"error,omitempty"`
+	Groups  []GroupResult `json:"groups"`
+}
+
+// Failed returns how many groups failed
+func (r SyncResult) Failed() int {
+	failed := 0
+	for _, group := range r.Groups {
+		if !group.Success {
+			failed++
+		}
+	}
+	return failed
+}
+
+// NewSyncResult builds the result of the updates reported by the requester,
+// groups are sorted by name. Urls and errors are redacted.
+func NewSyncResult(run SyncRun, updates []StatusUpdate) SyncResult {
+	groups := map[string]*GroupResult{}
+	for _, update := range updates {
+		group, ok := groups[update.Service]
+		if !ok {
+			group = &GroupResult{Group: update.Service, Success: true, Steps: []StepResult{}}
+			groups[update.Service] = group
+		}
+
+		step := StepResult{
+			Step:         update.Step,
+			Method:       update.Method,
+			Url:          update.Url,
+			Success:      update.Status == Success || update.Status == InSync,
+			InSync:       update.Status == InSync,
+			StatusCode:   update.StatusCode,
+			ResponseSize: update.ResponseSize,
+			DurationMs:   float64(update.Duration.Microseconds()) / 1000,
+		}
+		if step.Url == "" {
+			step.Url = Redact(update.Path)
+		}
+		if update.Error != nil {
+			step.Error = Redact(update.Error.Error())
+		}
+		group.Success = group.Success && step.Success
+		group.InSync = group.InSync || step.InSync
+		group.Steps = append(group.Steps, step)
+	}
+
+	result := SyncResult{Port: run.Port, RunID: run.ID, Attempt: run.Attempt, Groups: []GroupResult{}}
+	for _, group := range groups {
+		result.Groups = append(result.Groups, *group)
+	}
+	sort.Slice(result.Groups, func(i, j int) bool {
+		return result.Groups[i].Group < result.Groups[j].Group
+	})
+	result.Success = result.Failed() == 0
+
+	return result
+}
@@ -0,0 +1,31 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncResult(t *testing.T) {
+	updates := []StatusUpdate{
+		{Service: "b", Step: 1, Path: "http://f.com/login", Status: Success},
+		{Service: "a", Step: 1, Path: "http://f.com/", Status: Success},
+		{Service: "b", Step: 2, Path: "http://f.com/port", Status: Error, Error: errors.New("boom")},
+	}
+
+	result := NewSyncResult(SyncRun{ID: "run", Port: 1337, Attempt: 1}, updates)
+	if result.Success || result.Failed() != 1 {
+		t.Fatalf("expected one failed group %+v", result)
+	}
+	if len(result.Groups) != 2 || result.Groups[0].Group != "a" || result.Groups[1].Group != "b" {
+		t.Fatalf("groups are not sorted by name %+v", result.Groups)
+	}
+	if !result.Groups[0].Success || result.Groups[1].Success {
+		t.Fatalf("unexpected group results %+v", result.Groups)
+	}
+
+	steps := result.Groups[1].Steps
+	if len(steps) != 2 || !steps[0].Success || steps[1].Success || steps[1].Error != "boom" {
+		t.Fatalf("unexpected step results %+v", steps)
+	}
+}
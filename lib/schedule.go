@@ -0,0 +1,123 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"sort"
+	"time"
+)
+
+// Resyncs due this close together share a run
+const resyncTolerance = time.Second
+
+// Scheduler keeps what the watch loop has to synchronize later, the groups
+// that failed and are retried and the periodic resyncs. It belongs to the
+// watch loop and isn't safe for concurrent use.
+type Scheduler struct {
+	requests map[string]RequestGroup
+	// pending has the run of the next attempt of every failed group
+	pending map[string]SyncRun
+	resyncs map[string]time.Time
+}
+
+// Retry is the next attempt of a run for the groups of it that failed
+type Retry struct {
+	Run    SyncRun
+	Groups map[string]RequestGroup
+}
+
+func NewScheduler(requests map[string]RequestGroup) *Scheduler {
+	return &Scheduler{requests: requests, pending: map[string]SyncRun{}, resyncs: map[string]time.Time{}}
+}
+
+// SetRequests switches to a new configuration, the resyncs of groups that
+// were removed or lost their interval are dropped
+func (s *Scheduler) SetRequests(requests map[string]RequestGroup) {
+	s.requests = requests
+	for name := range s.resyncs {
+		if requests[name].ResyncInterval <= 0 {
+			delete(s.resyncs, name)
+		}
+	}
+}
+
+// TakePending returns the retries of the pending groups that still exist,
+// one per run, and clears them
+func (s *Scheduler) TakePending() []Retry {
+	retries := []Retry{}
+	byID := map[string]int{}
+	for _, name := range s.Pending() {
+		group, ok := s.requests[name]
+		if !ok {
+			continue
+		}
+		run := s.pending[name]
+		i, ok := byID[run.ID]
+		if !ok {
+			i = len(retries)
+			byID[run.ID] = i
+			retries = append(retries, Retry{Run: run, Groups: map[string]RequestGroup{}})
+		}
+		retries[i].Groups[name] = group
+	}
+	s.pending = map[string]SyncRun{}
+	return retries
+}
+
+// Pending returns the groups waiting to be retried
+func (s *Scheduler) Pending() []string {
+	names := make([]string, 0, len(s.pending))
+	for name := range s.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Synced records the outcome of run finished at now, failed groups are
+// retried and every group with an interval is resynced after it, the failed
+// ones too in case the retries give up. A group that was already waiting to
+// be retried for the same port keeps the run of that retry.
+func (s *Scheduler) Synced(run SyncRun, result SyncResult, now time.Time) {
+	for _, group := range result.Groups {
+		pending, ok := s.pending[group.Group]
+		switch {
+		case group.Success:
+			delete(s.pending, group.Group)
+		case !ok || pending.Port != run.Port:
+			next := run
+			next.Attempt++
+			s.pending[group.Group] = next
+		}
+		if interval := s.requests[group.Group].ResyncInterval; interval > 0 {
+			s.resyncs[group.Group] = now.Add(interval)
+		}
+	}
+}
+
+// NextResync returns when the earliest resync is due
+func (s *Scheduler) NextResync() (time.Time, bool) {
+	var next time.Time
+	for _, at := range s.resyncs {
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// DueResyncs takes the groups due at now, or shortly after, so they share the
+// run. They are planned again once synced. Groups waiting to be retried are
+// left to their retry, which plans their resync.
+func (s *Scheduler) DueResyncs(now time.Time) map[string]RequestGroup {
+	due := map[string]RequestGroup{}
+	for name, at := range s.resyncs {
+		if !at.Before(now.Add(resyncTolerance)) {
+			continue
+		}
+		delete(s.resyncs, name)
+		if _, ok := s.pending[name]; !ok {
+			due[name] = s.requests[name]
+		}
+	}
+	return due
+}
@@ -0,0 +1,117 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	requests := map[string]RequestGroup{
+		"resynced": {ResyncInterval: time.Minute},
+		"failing":  {ResyncInterval: 2 * time.Minute},
+		"plain":    {},
+	}
+	scheduler := NewScheduler(requests)
+	run := SyncRun{ID: "port-change", Port: 1337, PreviousPort: 1000, Attempt: 1}
+
+	scheduler.Synced(run, SyncResult{Groups: []GroupResult{
+		{Group: "resynced", Success: true},
+		{Group: "failing", Success: false},
+		{Group: "plain", Success: true},
+	}}, now)
+
+	t.Run("Failed group", func(t *testing.T) {
+		if pending := scheduler.Pending(); len(pending) != 1 || pending[0] != "failing" {
+			t.Fatalf("expected the failed group to be retried %v", pending)
+		}
+		if next, ok := scheduler.NextResync(); !ok || !next.Equal(now.Add(time.Minute)) {
+			t.Fatalf("expected the earliest resync in a minute but got %s", next)
+		}
+	})
+
+	t.Run("Other runs", func(t *testing.T) {
+		api := SyncRun{ID: "api", Port: 1337, Attempt: 1}
+		scheduler.Synced(api, SyncResult{Groups: []GroupResult{{Group: "failing", Success: false}}}, now)
+		retries := scheduler.TakePending()
+		if len(retries) != 1 || retries[0].Run.ID != run.ID || retries[0].Run.Attempt != 2 || retries[0].Run.PreviousPort != 1000 {
+			t.Fatalf("another run of the same port keeps the pending retry %+v", retries)
+		}
+		if len(scheduler.Pending()) != 0 {
+			t.Fatalf("expected the retries to be taken %v", scheduler.Pending())
+		}
+
+		scheduler.Synced(retries[0].Run, SyncResult{Groups: []GroupResult{{Group: "failing", Success: false}}}, now)
+		scheduler.Synced(api, SyncResult{Groups: []GroupResult{{Group: "plain", Success: false}}}, now)
+		retries = scheduler.TakePending()
+		if len(retries) != 2 || retries[0].Run.ID != run.ID || retries[0].Run.Attempt != 3 || retries[1].Run.ID != api.ID {
+			t.Fatalf("expected a retry for every run %+v", retries)
+		}
+
+		change := SyncRun{ID: "new-port", Port: 1338, PreviousPort: 1337, Attempt: 1}
+		scheduler.Synced(run, SyncResult{Groups: []GroupResult{{Group: "failing", Success: false}}}, now)
+		scheduler.Synced(change, SyncResult{Groups: []GroupResult{{Group: "failing", Success: false}}}, now)
+		if retries := scheduler.TakePending(); len(retries) != 1 || retries[0].Run.ID != change.ID {
+			t.Fatalf("a new port replaces the pending retry %+v", retries)
+		}
+		scheduler.Synced(run, SyncResult{Groups: []GroupResult{{Group: "failing", Success: false}}}, now)
+		scheduler.Synced(api, SyncResult{Groups: []GroupResult{{Group: "failing", Success: true}}}, now)
+		if pending := scheduler.Pending(); len(pending) != 0 {
+			t.Fatalf("a group synchronized by another run isn't retried %v", pending)
+		}
+	})
+
+	t.Run("Due", func(t *testing.T) {
+		if due := scheduler.DueResyncs(now.Add(58 * time.Second)); len(due) != 0 {
+			t.Fatalf("nothing is due yet %v", due)
+		}
+		due := scheduler.DueResyncs(now.Add(time.Minute - 500*time.Millisecond))
+		if _, ok := due["resynced"]; len(due) != 1 || !ok {
+			t.Fatalf("expected the group due within a second %v", due)
+		}
+		if next, _ := scheduler.NextResync(); !next.Equal(now.Add(2 * time.Minute)) {
+			t.Fatalf("the due group must be taken until it is synced again %s", next)
+		}
+
+		scheduler.Synced(run, SyncResult{Groups: []GroupResult{{Group: "resynced", Success: true}}}, now.Add(time.Minute))
+		if next, _ := scheduler.NextResync(); !next.Equal(now.Add(2 * time.Minute)) {
+			t.Fatalf("expected both groups due in two minutes but got %s", next)
+		}
+		if due := scheduler.DueResyncs(now.Add(2 * time.Minute)); len(due) != 2 {
+			t.Fatalf("groups due at the same time share the run %v", due)
+		}
+	})
+
+	t.Run("Due while retrying", func(t *testing.T) {
+		scheduler.Synced(run, SyncResult{Groups: []GroupResult{
+			{Group: "resynced", Success: true},
+			{Group: "failing", Success: false},
+		}}, now)
+		due := scheduler.DueResyncs(now.Add(2 * time.Minute))
+		if _, ok := due["resynced"]; len(due) != 1 || !ok {
+			t.Fatalf("the group waiting to be retried isn't resynchronized %v", due)
+		}
+		if _, ok := scheduler.NextResync(); ok {
+			t.Fatal("the resync of the pending group is planned after its retry")
+		}
+		if retries := scheduler.TakePending(); len(retries) != 1 || retries[0].Run.ID != run.ID {
+			t.Fatalf("the resync doesn't take over the retry %+v", retries)
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		scheduler.Synced(run, SyncResult{Groups: []GroupResult{
+			{Group: "resynced", Success: true},
+			{Group: "failing", Success: false},
+		}}, now)
+		scheduler.SetRequests(map[string]RequestGroup{"resynced": {}, "plain": {}})
+		if next, ok := scheduler.NextResync(); ok {
+			t.Fatalf("the interval was removed but a resync is planned at %s", next)
+		}
+
+		if pending := scheduler.TakePending(); len(pending) != 0 {
+			t.Fatalf("removed groups aren't synchronized %v", pending)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+type Schema map[string]any
+
+// GenerateSchema builds the JSON Schema of the configuration file out of the
+// mapstructure and validator tags of the configuration structs. Top level keys
+// in defaults, e.g. the ones that have a flag, are not required in the file.
+func GenerateSchema(defaults map[string]any) Schema {
+	schema := typeSchema(reflect.TypeOf(Configuration{}), nil)
+	schema["$schema"] = schemaDraft
+	schema["title"] = "gluetun-sync configuration"
+
+	properties := schema["properties"].(Schema)
+	fileRequired, _ := schema["required"].([]string)
+	required := []string{}
+	for _, name := range fileRequired {
+		if _, ok := defaults[name]; !ok {
+			required = append(required, name)
+		}
+	}
+	delete(schema, "required")
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	for name, value := range defaults {
+		if property, ok := properties[name].(Schema); ok {
+			property["default"] = value
+		}
+	}
+
+	return schema
+}
+
+const durationPattern = `^[-+]?(0|(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|ms|s|m|h))+)$`
+
+// typeSchema returns the schema of t, rules are the validator rules that apply
+// to t itself, the ones after dive are passed down to the elements
+func typeSchema(t reflect.Type, rules []string) Schema {
+	// Durations are decoded from strings like 1h30m, 0 or a number of
+	// nanoseconds
+	if t == reflect.TypeOf(time.Duration(0)) {
+		schema := Schema{"type": []string{"string", "integer"}, "pattern": durationPattern}
+		applyRules(schema, rules)
+		return schema
+	}
+
+	var schema Schema
+	elemRules := []string{}
+	for i, rule := range rules {
+		if rule == "dive" {
+			rules, elemRules = rules[:i], rules[i+1:]
+			break
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), rules)
+	case reflect.Struct:
+		schema = structSchema(t)
+	case reflect.Map:
+		schema = Schema{"type": "object", "additionalProperties": typeSchema(t.Elem(), elemRules)}
+	case reflect.Slice, reflect.Array:
+		schema = Schema{"type": "array", "items": typeSchema(t.Elem(), elemRules)}
+	case reflect.String:
+		schema = Schema{"type": "string"}
+	case reflect.Bool:
+		schema = Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema = Schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema = Schema{"type": "integer", "minimum": 0}
+		if t.Bits() < 64 {
+			schema["maximum"] = uint64(math.MaxUint64 >> (64 - t.Bits()))
+		}
+	case reflect.Float32, reflect.Float64:
+		schema = Schema{"type": "number"}
+	default:
+		schema = Schema{}
+	}
+
+	applyRules(schema, rules)
+	return schema
+}
+
+func structSchema(t reflect.Type) Schema {
+	properties := Schema{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		rules := validationRules(field)
+		if options == "squash" {
+			embedded := structSchema(field.Type)
+			for key, value := range embedded["properties"].(Schema) {
+				properties[key] = value
+			}
+			if embeddedRequired, ok := embedded["required"]; ok {
+				required = append(required, embeddedRequired.([]string)...)
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		properties[name] = typeSchema(field.Type, rules)
+		if len(rules) > 0 && rules[0] == "required" {
+			required = append(required, name)
+		}
+	}
+
+	schema := Schema{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func validationRules(field reflect.StructField) []string {
+	tag := field.Tag.Get("validate")
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+// applyRules maps the validator rules that have a JSON Schema equivalent, the
+// rest are only enforced by the validator
+func applyRules(schema Schema, rules []string) {
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "oneof":
+			enum := []any{}
+			for _, value := range strings.Fields(param) {
+				if n, err := strconv.Atoi(value); err == nil && schema["type"] == "integer" {
+					enum = append(enum, n)
+				} else {
+					enum = append(enum, value)
+				}
+			}
+			schema["enum"] = enum
+		case "http_url", "url":
+			schema["format"] = "uri"
+		case "gt", "gte", "min":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			if name == "gt" {
+				n++
+			}
+			switch schema["type"] {
+			case "object":
+				schema["minProperties"] = n
+			case "array":
+				schema["minItems"] = n
+			case "string":
+				schema["minLength"] = n
+			case "integer", "number":
+				if name == "gt" {
+					schema["exclusiveMinimum"] = n - 1
+				} else {
+					schema["minimum"] = n
+				}
+			}
+		case "max", "lte":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			switch schema["type"] {
+			case "array":
+				schema["maxItems"] = n
+			case "string":
+				schema["maxLength"] = n
+			case "integer", "number":
+				schema["maximum"] = n
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestGenerateSchema(t *testing.T) {
+	schema := GenerateSchema(map[string]any{"port-file": "/tmp/portfile"})
+
+	if _, ok := schema["required"]; ok {
+		t.Fatalf("port-file has a default and shouldn't be required %v", schema["required"])
+	}
+
+	properties := schema["properties"].(Schema)
+	if properties["port-file"].(Schema)["default"] != "/tmp/portfile" {
+		t.Fatalf("expected port-file default to be set %v", properties["port-file"])
+	}
+
+	requests := properties["requests"].(Schema)
+	if requests["minProperties"] != 1 {
+		t.Fatalf("expected requests to require at least one group %v", requests)
+	}
+
+	group := requests["additionalProperties"].(Schema)
+	if !reflect.DeepEqual(group["required"], []string{"requests"}) {
+		t.Fatalf("expected group to require requests %v", group["required"])
+	}
+
+	request := group["properties"].(Schema)["requests"].(Schema)["items"].(Schema)
+	requestProperties := request["properties"].(Schema)
+	if !reflect.DeepEqual(requestProperties["method"].(Schema)["enum"], []any{"GET", "POST", "PUT", "DELETE", "OPTION"}) {
+		t.Fatalf("expected method enum %v", requestProperties["method"])
+	}
+	if requestProperties["url"].(Schema)["format"] != "uri" {
+		t.Fatalf("expected url to have the uri format %v", requestProperties["url"])
+	}
+	if request["additionalProperties"] != false {
+		t.Fatal("unknown keys shouldn't be allowed")
+	}
+
+	maxAge := properties["history-max-age"].(Schema)
+	if !reflect.DeepEqual(maxAge["type"], []string{"string", "integer"}) {
+		t.Fatalf("expected durations to be strings or integers %v", maxAge)
+	}
+	pattern := regexp.MustCompile(maxAge["pattern"].(string))
+	for _, value := range []string{"0", "720h", "1h30m", "1.5s", "-5m"} {
+		if _, err := time.ParseDuration(value); err != nil || !pattern.MatchString(value) {
+			t.Fatalf("expected %s to be a valid duration %v", value, err)
+		}
+	}
+	for _, value := range []string{"7d", "", "h"} {
+		if pattern.MatchString(value) {
+			t.Fatalf("expected %s to be an invalid duration", value)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Only the braced form is expanded so passwords containing a $ are left alone,
+// ${VAR:-default} is supported for optional variables
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+var templateFuncs = template.FuncMap{
+	"file":   templateFile,
+	"env":    os.Getenv,
+	"secret": templateSecret,
+}
+
+func ExpandEnv(s string) (string, error) {
+	return expandEnv(s, func(name string, value string) (string, error) {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	})
+}
+
+// expandEnv replaces ${VAR} in value, the unset variables without a default
+// are replaced by what unset returns
+func expandEnv(value string, unset func(name string, value string) (string, error)) (string, error) {
+	var err error
+	expanded := envPattern.ReplaceAllStringFunc(value, func(match string) string {
+		groups := envPattern.FindStringSubmatch(match)
+		if value, ok := os.LookupEnv(groups[1]); ok {
+			return value
+		}
+		if groups[2] != "" {
+			return groups[3]
+		}
+		replacement, unsetErr := unset(groups[1], value)
+		if unsetErr != nil {
+			if err == nil {
+				err = unsetErr
+			}
+			return match
+		}
+		return replacement
+	})
+
+	return expanded, err
+}
+
+// ExpandEnvHook expands ${ENV} in every string of the configuration while it
+// is being decoded
+func ExpandEnvHook() mapstructure.DecodeHookFuncKind {
+	return func(from reflect.Kind, to reflect.Kind, data interface{}) (interface{}, error) {
+		if from != reflect.String {
+			return data, nil
+		}
+		return ExpandEnv(data.(string))
+	}
+}
+
+// placeholderEnvHook replaces the unset variables with placeholders instead of
+// failing, missing is called with their names. The placeholder is a zero for
+// numbers, an url at the start of a value and something that passes as a host
+// or a port anywhere else.
+func placeholderEnvHook(missing func(name string)) mapstructure.DecodeHookFuncKind {
+	return func(from reflect.Kind, to reflect.Kind, data interface{}) (interface{}, error) {
+		if from != reflect.String {
+			return data, nil
+		}
+		return expandEnv(data.(string), func(name string, value string) (string, error) {
+			missing(name)
+			switch {
+			case to != reflect.String && to != reflect.Slice && to != reflect.Interface:
+				return "0", nil
+			case strings.HasPrefix(value, "${"+name):
+				return "http://placeholder.invalid", nil
+			}
+			return "1", nil
+		})
+	}
+}
+
+// DecodeHook is the hook the configuration has to be unmarshaled with, it
+// keeps the viper defaults
+func DecodeHook() mapstructure.DecodeHookFunc {
+	return decodeHook(ExpandEnvHook())
+}
+
+// ValidationDecodeHook is DecodeHook for checking a configuration on another
+// machine than the deployment, the environment variables that aren't set
+// there don't fail the decoding and are passed to missing
+func ValidationDecodeHook(missing func(name string)) mapstructure.DecodeHookFunc {
+	return decodeHook(placeholderEnvHook(missing))
+}
+
+func decodeHook(expandEnv mapstructure.DecodeHookFuncKind) mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		expandEnv,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)
+}
+
+// readSecret returns the content of file if set or value otherwise. Files are
+// read every time so rotated secrets are picked up.
+func readSecret(value string, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read secret file %w", err)
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// APIToken returns the token of the control api, the file is read every time
+// so it can be rotated
+func (c Configuration) APIToken() (string, error) {
+	return readSecret(c.ApiToken, c.ApiTokenFile)
+}
+
+func (c Credentials) resolve() (Credentials, error) {
+	var err error
+	resolved := Credentials{}
+	resolved.Username, err = readSecret(c.Username, c.UsernameFile)
+	if err != nil {
+		return resolved, err
+	}
+
+	resolved.Password, err = readSecret(c.Password, c.PasswordFile)
+	if err != nil {
+		return resolved, err
+	}
+	AddSecret(resolved.Password)
+
+	return resolved, nil
+}
+
+func (a Auth) resolve() (*Auth, error) {
+	var err error
+	resolved := a
+	resolved.Password, err = readSecret(a.Password, a.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved.Token, err = readSecret(a.Token, a.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	resolved.Token = strings.TrimSpace(resolved.Token)
+
+	resolved.ClientSecret, err = readSecret(a.ClientSecret, a.ClientSecretFile)
+	if err != nil {
+		return nil, err
+	}
+
+	AddSecret(resolved.Password)
+	AddSecret(resolved.Token)
+	AddSecret(resolved.ClientSecret)
+
+	resolved.PasswordFile, resolved.TokenFile, resolved.ClientSecretFile = "", "", ""
+	return &resolved, nil
+}
+
+// Files are treated as secrets, like Docker secrets, environment variables
+// only when they go through secret since many are just hosts or ports
+func templateFile(path string) (string, error) {
+	secret, err := readSecret("", path)
+	AddSecret(secret)
+	return secret, err
+}
+
+func templateSecret(value string) string {
+	AddSecret(value)
+	return value
+}
@@ -0,0 +1,267 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	GroupPending = "pending"
+	GroupSynced  = "synced"
+	GroupFailed  = "failed"
+)
+
+// The watch loop records a heartbeat every HeartbeatInterval and every step
+// of a sync counts as one too, it is considered stuck when it doesn't for
+// heartbeatTimeout, longer than a few requests timing out
+const (
+	HeartbeatInterval = 30 * time.Second
+	heartbeatTimeout  = 5 * time.Minute
+)
+
+// GroupState is the sync status of a group, Port is the last port it was
+// synchronized with and Steps the outcome of its last sync
+type GroupState struct {
+	Name        string       `json:"name"`
+	Status      string       `json:"status"`
+	Port        uint16       `json:"port,omitempty"`
+	LastSync    time.Time    `json:"last_sync"`
+	LastSuccess time.Time    `json:"last_success"`
+	Error       string       `json:"error,omitempty"`
+	Steps       []StepResult `json:"steps,omitempty"`
+}
+
+// StateSnapshot is a copy of the state safe to use from any goroutine
+type StateSnapshot struct {
+	Watching    bool         `json:"watching"`
+	PortFile    string       `json:"port_file,omitempty"`
+	Port        uint16       `json:"port,omitempty"`
+	PortChanged time.Time    `json:"port_changed"`
+	Groups      []GroupState `json:"groups"`
+}
+
+// State tracks what the watcher is doing, it is fed as a Reporter and by the
+// watch loop
+type State struct {
+	mu             sync.Mutex
+	watching       bool
+	portFile       string
+	port           uint16
+	portChanged    time.Time
+	groups         map[string]*GroupState
+	updates        map[string][]StatusUpdate
+	run            SyncRun
+	readyThreshold time.Duration
+	heartbeat      time.Time
+}
+
+func NewState() *State {
+	return &State{groups: map[string]*GroupState{}, updates: map[string][]StatusUpdate{}}
+}
+
+func (s *State) SetWatching(portFile string, watching bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.portFile = portFile
+	s.watching = watching
+}
+
+func (s *State) ObservePort(port uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if port != s.port {
+		s.port = port
+		s.portChanged = time.Now()
+	}
+}
+
+// Heartbeat records that the watch loop is still responsive
+func (s *State) Heartbeat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.heartbeat = time.Now()
+}
+
+// SetGroups sets the configured groups, the status of the ones that already
+// existed is kept
+func (s *State) SetGroups(names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := map[string]*GroupState{}
+	for _, name := range names {
+		if group, ok := s.groups[name]; ok {
+			groups[name] = group
+			continue
+		}
+		groups[name] = &GroupState{Name: name, Status: GroupPending}
+	}
+	s.groups = groups
+}
+
+// SetReadyThreshold sets how long groups can take to synchronize a new port
+// before the instance is not ready anymore
+func (s *State) SetReadyThreshold(threshold time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readyThreshold = threshold
+}
+
+func (s *State) SyncStarted(run SyncRun, groups []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.run = run
+	s.heartbeat = time.Now()
+}
+
+func (s *State) GroupStarted(group string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updates[group] = nil
+	s.heartbeat = time.Now()
+}
+
+func (s *State) StepFinished(update StatusUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updates[update.Service] = append(s.updates[update.Service], update)
+	s.heartbeat = time.Now()
+}
+
+func (s *State) GroupFinished(group string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.groups[group]
+	if !ok {
+		state = &GroupState{Name: group}
+		s.groups[group] = state
+	}
+
+	state.LastSync = time.Now()
+	state.Steps = nil
+	if result := NewSyncResult(s.run, s.updates[group]); len(result.Groups) > 0 {
+		state.Steps = result.Groups[0].Steps
+	}
+	delete(s.updates, group)
+	if err != nil {
+		state.Status = GroupFailed
+		state.Error = Redact(err.Error())
+		return
+	}
+	state.Status = GroupSynced
+	state.Port = s.run.Port
+	state.LastSuccess = state.LastSync
+	state.Error = ""
+}
+
+func (s *State) SyncFinished(err error) {}
+
+func (s *State) Snapshot() StateSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := StateSnapshot{
+		Watching:    s.watching,
+		PortFile:    s.portFile,
+		Port:        s.port,
+		PortChanged: s.portChanged,
+		Groups:      []GroupState{},
+	}
+	for _, name := range sortedKeys(s.groups) {
+		snapshot.Groups = append(snapshot.Groups, *s.groups[name])
+	}
+	return snapshot
+}
+
+// Ready returns why the instance is not ready, nothing if it is. It is ready
+// once the port is known and every group synchronized it, groups behind are
+// tolerated for the ready threshold after a port change.
+func (s *State) Ready() []string {
+	snapshot := s.Snapshot()
+	s.mu.Lock()
+	threshold := s.readyThreshold
+	s.mu.Unlock()
+
+	if snapshot.Port == 0 {
+		return []string{"the port is not known yet"}
+	}
+
+	reasons := []string{}
+	late := time.Since(snapshot.PortChanged) > threshold
+	for _, group := range snapshot.Groups {
+		if group.Port == snapshot.Port || !late {
+			continue
+		}
+		reason := fmt.Sprintf("group %s didn't synchronize port %d within %s", group.Name, snapshot.Port, threshold)
+		if group.Error != "" {
+			reason += ": " + group.Error
+		}
+		reasons = append(reasons, reason)
+	}
+	return reasons
+}
+
+type healthResponse struct {
+	Status  string         `json:"status"`
+	Reasons []string       `json:"reasons,omitempty"`
+	State   *StateSnapshot `json:"state,omitempty"`
+}
+
+// Healthy returns why the instance is unhealthy, nothing if it is. It is
+// healthy while the port file is watched and the watch loop isn't stuck.
+func (s *State) Healthy() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.watching {
+		return []string{"the port file is not being watched"}
+	}
+	if since := time.Since(s.heartbeat); since > heartbeatTimeout {
+		return []string{fmt.Sprintf("the watch loop didn't respond for %s", since.Round(time.Second))}
+	}
+	return nil
+}
+
+// HealthHandler answers 200 when the instance is Healthy
+func (s *State) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reasons := s.Healthy(); len(reasons) > 0 {
+			WriteJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "unhealthy", Reasons: reasons})
+			return
+		}
+		WriteJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+	})
+}
+
+// ReadyHandler answers 200 when the instance is Ready
+func (s *State) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot := s.Snapshot()
+		if reasons := s.Ready(); len(reasons) > 0 {
+			WriteJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "not ready", Reasons: reasons, State: &snapshot})
+			return
+		}
+		WriteJSON(w, http.StatusOK, healthResponse{Status: "ready", State: &snapshot})
+	})
+}
+
+// WriteJSON answers with code and response encoded as JSON
+func WriteJSON(w http.ResponseWriter, code int, response any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(response)
+}
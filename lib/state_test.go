@@ -0,0 +1,76 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	state := NewState()
+	state.SetGroups([]string{"a", "b"})
+	state.SetReadyThreshold(time.Minute)
+
+	status := func(handler string) int {
+		recorder := httptest.NewRecorder()
+		if handler == "health" {
+			state.HealthHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+		} else {
+			state.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+		}
+		return recorder.Code
+	}
+
+	if status("health") != 503 || status("ready") != 503 {
+		t.Fatal("nothing is watched yet")
+	}
+
+	state.SetWatching("/tmp/port", true)
+	state.ObservePort(1337)
+	if status("health") != 503 {
+		t.Fatal("the watch loop didn't record a heartbeat yet")
+	}
+	state.Heartbeat()
+	if status("health") != 200 {
+		t.Fatal("the port file is watched")
+	}
+	if status("ready") != 200 {
+		t.Fatal("groups have the threshold to synchronize")
+	}
+
+	state.heartbeat = time.Now().Add(-heartbeatTimeout - time.Second)
+	if reasons := state.Healthy(); len(reasons) != 1 || status("health") != 503 {
+		t.Fatalf("the watch loop is stuck %v", reasons)
+	}
+	state.SyncStarted(SyncRun{Port: 1337, Attempt: 1}, []string{"a", "b"})
+	if status("health") != 200 {
+		t.Fatal("a sync in progress shows the loop is responsive")
+	}
+	state.GroupStarted("a")
+	state.StepFinished(StatusUpdate{Service: "a", Step: 1, Method: "POST", Url: "http://f.com", Status: Success, StatusCode: 200})
+	state.GroupFinished("a", nil)
+	state.GroupStarted("b")
+	state.StepFinished(StatusUpdate{Service: "b", Step: 1, Status: Error, Error: errors.New("boom")})
+	state.GroupFinished("b", errors.New("boom"))
+
+	state.SetReadyThreshold(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	reasons := state.Ready()
+	if len(reasons) != 1 || status("ready") != 503 {
+		t.Fatalf("b didn't synchronize the port %v", reasons)
+	}
+
+	state.SetGroups([]string{"a"})
+	if reasons := state.Ready(); len(reasons) != 0 {
+		t.Fatalf("removed groups don't count %v", reasons)
+	}
+	snapshot := state.Snapshot()
+	if len(snapshot.Groups) != 1 || snapshot.Groups[0].Status != GroupSynced || snapshot.Groups[0].Port != 1337 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	if steps := snapshot.Groups[0].Steps; len(steps) != 1 || !steps[0].Success || steps[0].StatusCode != 200 {
+		t.Fatalf("expected the steps of the last sync %+v", steps)
+	}
+}
@@ -0,0 +1,333 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	decodePathPattern  = regexp.MustCompile(`'([^']*)'`)
+	invalidKeysPattern = regexp.MustCompile(`^'([^']*)' has invalid keys: (.*)$`)
+	pathSegmentPattern = regexp.MustCompile(`[^.\[\]]+`)
+)
+
+// Templates are only checked, secret files and the environment of the machine
+// validating the config don't need to be the ones of the deployment
+var validationFuncs = template.FuncMap{
+	"file":   func(path string) string { return "" },
+	"env":    func(name string) string { return "" },
+	"secret": func(value string) string { return value },
+}
+
+// ConfigError is a configuration problem located by its key path, e.g.
+// requests.torrent.requests[0].url
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// NewValidator returns a validator that names fields after their config keys
+func NewValidator() *validator.Validate {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	return validate
+}
+
+// ValidateConfig checks the schema and that every template compiles
+func ValidateConfig(c Configuration) []ConfigError {
+	errs := ConfigErrors(NewValidator().Struct(c))
+	if c.ApiListen != "" && c.ApiToken == "" && c.ApiTokenFile == "" {
+		errs = append(errs, ConfigError{Path: "api-token", Message: "api-listen requires api-token or api-token-file"})
+	}
+
+	services := make([]string, 0, len(c.Requests))
+	for service := range c.Requests {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	for _, service := range services {
+		for k, request := range c.Requests[service].Requests {
+			path := fmt.Sprintf("requests.%s.requests[%d]", service, k)
+			errs = append(errs, validateRequest(path, request)...)
+		}
+		for k, request := range c.Requests[service].OnFailure {
+			path := fmt.Sprintf("requests.%s.on-failure[%d]", service, k)
+			errs = append(errs, validateRequest(path, request)...)
+		}
+
+		if check := c.Requests[service].Check; check != nil {
+			path := fmt.Sprintf("requests.%s.check", service)
+			errs = append(errs, validateProbe(path, *check)...)
+		}
+		if verify := c.Requests[service].Verify; verify != nil {
+			path := fmt.Sprintf("requests.%s.verify", service)
+			errs = append(errs, validateProbe(path, *verify)...)
+		}
+	}
+
+	return errs
+}
+
+func validateRequest(path string, request Request) []ConfigError {
+	errs := []ConfigError{}
+	if err := checkTemplate(request.Url); err != nil {
+		errs = append(errs, ConfigError{Path: path + ".url", Message: err.Error()})
+	}
+	if err := checkTemplate(request.Payload); err != nil {
+		errs = append(errs, ConfigError{Path: path + ".payload", Message: err.Error()})
+	}
+	for i, assertion := range request.Assert {
+		path := fmt.Sprintf("%s.assert[%d]", path, i)
+		if err := checkTemplate(assertion.Equals); err != nil {
+			errs = append(errs, ConfigError{Path: path + ".equals", Message: err.Error()})
+		}
+		if _, err := regexp.Compile(assertion.Regex); err != nil {
+			errs = append(errs, ConfigError{Path: path + ".regex", Message: err.Error()})
+		}
+	}
+
+	return errs
+}
+
+func validateProbe(path string, probe Probe) []ConfigError {
+	errs := []ConfigError{}
+	if err := checkTemplate(probe.Url); err != nil {
+		errs = append(errs, ConfigError{Path: path + ".url", Message: err.Error()})
+	}
+	if err := checkTemplate(probe.Payload); err != nil {
+		errs = append(errs, ConfigError{Path: path + ".payload", Message: err.Error()})
+	}
+	if err := checkTemplate(probe.Expected); err != nil {
+		errs = append(errs, ConfigError{Path: path + ".expected", Message: err.Error()})
+	}
+	if _, err := regexp.Compile(probe.Regex); err != nil {
+		errs = append(errs, ConfigError{Path: path + ".regex", Message: err.Error()})
+	}
+
+	return errs
+}
+
+func checkTemplate(templateStr string) error {
+	templ, err := template.New("template").Funcs(validationFuncs).Parse(templateStr)
+	if err != nil {
+		return err
+	}
+
+	data := templateData{Credentials: Credentials{Username: "username", Password: "password"}, Port: 1}
+	return templ.Execute(io.Discard, data)
+}
+
+// ConfigErrors converts decoding and validation errors into ConfigErrors
+func ConfigErrors(err error) []ConfigError {
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		errs := []ConfigError{}
+		for _, e := range validationErrs {
+			_, path, _ := strings.Cut(e.Namespace(), ".")
+			errs = append(errs, ConfigError{Path: normalizePath(path), Message: validationMessage(e)})
+		}
+		return errs
+	}
+
+	var decodeErr *mapstructure.Error
+	if errors.As(err, &decodeErr) {
+		errs := []ConfigError{}
+		for _, e := range decodeErr.Errors {
+			errs = append(errs, decodeErrors(e)...)
+		}
+		return errs
+	}
+
+	return []ConfigError{{Message: err.Error()}}
+}
+
+func decodeErrors(msg string) []ConfigError {
+	if match := invalidKeysPattern.FindStringSubmatch(msg); match != nil {
+		errs := []ConfigError{}
+		for _, key := range strings.Split(match[2], ", ") {
+			path := key
+			if match[1] != "" {
+				path = match[1] + "." + key
+			}
+			errs = append(errs, ConfigError{Path: normalizePath(path), Message: "unknown key"})
+		}
+		return errs
+	}
+
+	if match := decodePathPattern.FindStringSubmatch(msg); match != nil {
+		message := strings.TrimPrefix(msg, "error decoding '"+match[1]+"': ")
+		return []ConfigError{{Path: normalizePath(match[1]), Message: message}}
+	}
+
+	return []ConfigError{{Message: msg}}
+}
+
+func validationMessage(e validator.FieldError) string {
+	switch e.Tag() {
+	case "required":
+		return "is required"
+	case "required_if":
+		return fmt.Sprintf("is required when %s", e.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s but is %v", e.Param(), e.Value())
+	case "http_url":
+		return fmt.Sprintf("must be an http url but is %v", e.Value())
+	case "filepath":
+		return fmt.Sprintf("must be a file path but is %v", e.Value())
+	case "gt":
+		return fmt.Sprintf("must have more than %s elements", e.Param())
+	}
+	return fmt.Sprintf("failed on the '%s' validation", e.Tag())
+}
+
+// PathSegments splits requests.svc.requests[0].url into its keys and indexes
+func PathSegments(path string) []string {
+	return pathSegmentPattern.FindAllString(path, -1)
+}
+
+// normalizePath writes map keys as dotted keys and only keeps the brackets for
+// list indexes, requests[svc].requests[0] becomes requests.svc.requests[0]
+func normalizePath(path string) string {
+	var normalized strings.Builder
+	for _, segment := range PathSegments(path) {
+		if _, err := strconv.Atoi(segment); err == nil {
+			normalized.WriteString("[" + segment + "]")
+			continue
+		}
+		if normalized.Len() > 0 {
+			normalized.WriteString(".")
+		}
+		normalized.WriteString(segment)
+	}
+
+	return normalized.String()
+}
+
+// LocateLine returns the line of the key path in the config file content or 0
+// if it can't be found. YAML and JSON are parsed, for TOML the keys are
+// searched in order.
+func LocateLine(content []byte, file string, path string) int {
+	segments := PathSegments(path)
+	if len(segments) == 0 {
+		return 0
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		node := yaml.Node{}
+		if err := yaml.Unmarshal(content, &node); err != nil {
+			return 0
+		}
+		return locateNode(&node, segments)
+	case ".toml":
+		return locateToml(content, segments)
+	}
+
+	return 0
+}
+
+// locateNode returns the line of the deepest segment it could find, pointing
+// to the parent is still better than nothing for keys that are missing
+func locateNode(node *yaml.Node, segments []string) int {
+	line := 0
+	for _, segment := range segments {
+		for node.Kind == yaml.DocumentNode || node.Kind == yaml.AliasNode {
+			if node.Kind == yaml.AliasNode {
+				node = node.Alias
+			} else if len(node.Content) > 0 {
+				node = node.Content[0]
+			} else {
+				return line
+			}
+		}
+
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if strings.EqualFold(node.Content[i].Value, segment) {
+					line = node.Content[i].Line
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if index, err := strconv.Atoi(segment); err == nil && index < len(node.Content) {
+				next = node.Content[index]
+				line = next.Line
+				break
+			}
+			// Legacy requests are a list of maps, look for the key in the items
+			for _, item := range node.Content {
+				if item.Kind != yaml.MappingNode {
+					continue
+				}
+				for i := 0; i+1 < len(item.Content); i += 2 {
+					if strings.EqualFold(item.Content[i].Value, segment) {
+						line = item.Content[i].Line
+						next = item.Content[i+1]
+					}
+				}
+			}
+		}
+
+		if next == nil {
+			return line
+		}
+		node = next
+	}
+
+	return line
+}
+
+func locateToml(content []byte, segments []string) int {
+	lines := bytes.Split(content, []byte("\n"))
+	line := 0
+	for _, segment := range segments {
+		key := strings.ToLower(segment)
+		for i := line; i < len(lines); i++ {
+			text := strings.ToLower(strings.TrimSpace(string(lines[i])))
+			text = strings.Trim(text, "[]")
+			if strings.HasPrefix(text, key+" ") || strings.HasPrefix(text, key+"=") ||
+				text == key || strings.HasSuffix(text, "."+key) || strings.Contains(text, "."+key+" ") {
+				line = i + 1
+				break
+			}
+		}
+	}
+
+	return line
+}
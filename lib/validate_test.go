@@ -0,0 +1,132 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	errs := ValidateConfig(Configuration{
+		PortFile: "/tmp/portfile",
+		Requests: map[string]RequestGroup{
+			"test": {
+				Requests: []Request{
+					{Method: "FETCH", Url: "http://f.com"},
+					{Url: "http://f.com/{{.Nope}}"},
+				},
+			},
+		},
+	})
+
+	expected := []string{"requests.test.requests[0].method", "requests.test.requests[1].url"}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors but got %v", len(expected), errs)
+	}
+	for i, path := range expected {
+		if errs[i].Path != path {
+			t.Fatalf("expected error for %s but got %s", path, errs[i].Path)
+		}
+	}
+}
+
+func TestValidateApiToken(t *testing.T) {
+	c := Configuration{
+		PortFile:  "/tmp/portfile",
+		ApiListen: "127.0.0.1:9998",
+		Requests:  map[string]RequestGroup{"test": {Requests: []Request{{Url: "http://f.com"}}}},
+	}
+
+	if errs := ValidateConfig(c); len(errs) != 1 || errs[0].Path != "api-token" {
+		t.Fatalf("expected the api token to be required but got %v", errs)
+	}
+
+	c.ApiTokenFile = "/run/secrets/api-token"
+	if errs := ValidateConfig(c); len(errs) != 0 {
+		t.Fatalf("expected the token file to be enough but got %v", errs)
+	}
+}
+
+func TestValidateCheck(t *testing.T) {
+	errs := ValidateConfig(Configuration{
+		PortFile: "/tmp/portfile",
+		Requests: map[string]RequestGroup{
+			"test": {
+				Requests: []Request{{Url: "http://f.com"}},
+				Check:    &Probe{Url: "http://f.com/{{.Nope}}", Regex: "port=("},
+			},
+		},
+	})
+
+	if len(errs) != 2 || errs[0].Path != "requests.test.check.url" || errs[1].Path != "requests.test.check.regex" {
+		t.Fatalf("expected the check url and regex to be invalid but got %v", errs)
+	}
+}
+
+func TestValidateOnFailure(t *testing.T) {
+	errs := ValidateConfig(Configuration{
+		PortFile: "/tmp/portfile",
+		Requests: map[string]RequestGroup{
+			"test": {
+				Requests: []Request{{Url: "http://f.com"}},
+				OnFailure: []Request{
+					{Url: "http://f.com/set?port={{.PreviousPort}}", Payload: "step {{.FailedStep}}: {{.Error}}"},
+					{Url: "http://f.com/{{.Nope}}"},
+				},
+			},
+		},
+	})
+
+	if len(errs) != 1 || errs[0].Path != "requests.test.on-failure[1].url" {
+		t.Fatalf("expected only the second on-failure url to be invalid but got %v", errs)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	errs := decodeErrors("'requests[svc].requests[0]' has invalid keys: bogus, extra")
+	if len(errs) != 2 || errs[0].Path != "requests.svc.requests[0].bogus" || errs[1].Path != "requests.svc.requests[0].extra" {
+		t.Fatalf("unexpected unknown keys errors %v", errs)
+	}
+
+	errs = decodeErrors("error decoding 'port-file': some error")
+	if len(errs) != 1 || errs[0].Path != "port-file" || errs[0].Message != "some error" {
+		t.Fatalf("unexpected decoding error %v", errs)
+	}
+}
+
+func TestLocateLine(t *testing.T) {
+	yamlContent := []byte(`port-file: "/tmp/portfile"
+requests:
+  svc:
+    requests:
+      - method: "GET"
+        url: "http://f.com"
+      - url: "http://f.com/2"
+`)
+	tomlContent := []byte(`port-file = "/tmp/portfile"
+
+[requests.svc]
+  [[requests.svc.requests]]
+    method = "GET"
+    url = "http://f.com"
+`)
+
+	cases := []struct {
+		content []byte
+		file    string
+		path    string
+		line    int
+	}{
+		{yamlContent, "config.yaml", "port-file", 1},
+		{yamlContent, "config.yaml", "requests.svc.requests[0].url", 6},
+		{yamlContent, "config.yaml", "requests.svc.requests[1].url", 7},
+		{yamlContent, "config.yaml", "requests.svc.requests[1].missing", 7},
+		{tomlContent, "config.toml", "requests.svc.requests[0].url", 6},
+		{tomlContent, "config.ini", "port-file", 0},
+	}
+
+	for _, c := range cases {
+		if line := LocateLine(c.content, c.file, c.path); line != c.line {
+			t.Fatalf("expected %s in %s to be at line %d but got %d", c.path, c.file, c.line, line)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPortUnknown    = errors.New("the port is not known yet")
+	ErrUnknownGroup   = errors.New("unknown group")
+	ErrGroupsWithPort = errors.New("groups can't be selected when setting the port, every group is synchronized")
+)
+
+// SyncFunc synchronizes groups as part of run
+type SyncFunc func(run SyncRun, groups map[string]RequestGroup) SyncResult
+
+// Watcher is what the watch loop knows, the configuration, the port and the
+// runs, and decides what is synchronized on every event. Every event starts a
+// run of its own, retries keep the run of the sync that failed. The loop only
+// owns the channels and timers. It isn't safe for concurrent use.
+type Watcher struct {
+	config    Configuration
+	port      uint16
+	last      SyncRun
+	scheduler *Scheduler
+	sync      SyncFunc
+	onPort    func(port, previous uint16)
+}
+
+// NewWatcher returns a Watcher of config that synchronizes with sync, onPort
+// is called every time the port changes
+func NewWatcher(config Configuration, sync SyncFunc, onPort func(port, previous uint16)) *Watcher {
+	return &Watcher{config: config, scheduler: NewScheduler(config.Requests), sync: sync, onPort: onPort}
+}
+
+func (w *Watcher) Config() Configuration {
+	return w.config
+}
+
+func (w *Watcher) Port() uint16 {
+	return w.port
+}
+
+// LastRun returns the run of the last sync
+func (w *Watcher) LastRun() SyncRun {
+	return w.last
+}
+
+// Pending returns the groups waiting to be retried
+func (w *Watcher) Pending() []string {
+	return w.scheduler.Pending()
+}
+
+// NextResync returns when the earliest resync is due
+func (w *Watcher) NextResync() (time.Time, bool) {
+	return w.scheduler.NextResync()
+}
+
+// SetPort switches to port and synchronizes every group with it, it returns
+// false if it already was the current port
+func (w *Watcher) SetPort(port uint16) bool {
+	if port == w.port {
+		return false
+	}
+
+	previous := w.switchPort(port)
+	w.syncNow(w.newRun(previous), w.config.Requests)
+	return true
+}
+
+// Sync synchronizes groups with the current port in a new run
+func (w *Watcher) Sync(groups map[string]RequestGroup) error {
+	if w.port == 0 {
+		return ErrPortUnknown
+	}
+
+	w.syncNow(w.newRun(0), groups)
+	return nil
+}
+
+// Retry synchronizes the groups that failed again, as another attempt of their
+// run
+func (w *Watcher) Retry() {
+	for _, retry := range w.scheduler.TakePending() {
+		w.syncNow(retry.Run, retry.Groups)
+	}
+}
+
+// Resync synchronizes the groups whose resync is due at now, it returns false
+// if there were none
+func (w *Watcher) Resync(now time.Time) bool {
+	due := w.scheduler.DueResyncs(now)
+	if len(due) == 0 {
+		return false
+	}
+
+	slog.Info("resynchronizing groups", "groups", len(due))
+	w.syncNow(w.newRun(0), due)
+	return true
+}
+
+// Command synchronizes the groups named, every group when there are none, as
+// asked through the control api. A port, if set, replaces the current one
+// first and every group is synchronized with it. Only the groups synchronized
+// are part of the result.
+func (w *Watcher) Command(port uint16, names []string) (SyncResult, error) {
+	if port != 0 && len(names) > 0 {
+		return SyncResult{}, ErrGroupsWithPort
+	}
+	groups, err := SelectGroups(w.config.Requests, names)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if len(names) == 0 {
+		groups = w.config.Requests
+	}
+
+	var previous uint16
+	if port != 0 && port != w.port {
+		previous = w.switchPort(port)
+		slog.Info("port set through the api", "port", port)
+	}
+	if w.port == 0 {
+		return SyncResult{}, ErrPortUnknown
+	}
+
+	slog.Info("synchronizing through the api", "groups", len(groups))
+	return w.syncNow(w.newRun(previous), groups), nil
+}
+
+// Reload switches to config and returns the groups that are new or changed.
+// A different port file is watched through switchPortFile, the current one is
+// kept if it fails.
+func (w *Watcher) Reload(config Configuration, switchPortFile func(file string) error) map[string]RequestGroup {
+	if config.PortFile != w.config.PortFile {
+		if err := switchPortFile(config.PortFile); err != nil {
+			slog.Warn("keep monitoring the previous port file", "file", w.config.PortFile)
+			config.PortFile = w.config.PortFile
+		}
+	}
+
+	changed := ChangedGroups(w.config.Requests, config.Requests)
+	w.config = config
+	w.scheduler.SetRequests(config.Requests)
+	return changed
+}
+
+// switchPort sets port and returns the one it replaces
+func (w *Watcher) switchPort(port uint16) uint16 {
+	previous := w.port
+	w.port = port
+	w.onPort(port, previous)
+	return previous
+}
+
+// newRun starts a run of the current port, previous is only set when the run
+// comes from a port change so templates never see a stale port
+func (w *Watcher) newRun(previous uint16) SyncRun {
+	run := NewSyncRun(w.port)
+	run.PreviousPort = previous
+	return run
+}
+
+// syncNow synchronizes groups as part of run, the ones that fail are retried
+// later
+func (w *Watcher) syncNow(run SyncRun, groups map[string]RequestGroup) SyncResult {
+	w.last = run
+	result := w.sync(run, groups)
+	w.scheduler.Synced(run, result, time.Now())
+	return result
+}
+
+// SelectGroups returns the groups with the given names, all of them must exist
+func SelectGroups(groups map[string]RequestGroup, names []string) (map[string]RequestGroup, error) {
+	selected := map[string]RequestGroup{}
+	for _, name := range names {
+		group, ok := groups[name]
+		if !ok {
+			return nil, fmt.Errorf("%w %s, the configured groups are %s", ErrUnknownGroup, name, strings.Join(GroupNames(groups), ", "))
+		}
+		selected[name] = group
+	}
+
+	return selected, nil
+}
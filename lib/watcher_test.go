@@ -0,0 +1,202 @@
+/* SPDX-License-Identifier: MIT */
+package lib
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeSync records what a Watcher synchronizes, the groups in failing fail
+type fakeSync struct {
+	failing map[string]bool
+	runs    []SyncRun
+	groups  [][]string
+}
+
+func (f *fakeSync) sync(run SyncRun, groups map[string]RequestGroup) SyncResult {
+	f.runs = append(f.runs, run)
+	f.groups = append(f.groups, GroupNames(groups))
+
+	result := SyncResult{Port: run.Port, RunID: run.ID, Attempt: run.Attempt, Success: true}
+	for _, name := range GroupNames(groups) {
+		result.Groups = append(result.Groups, GroupResult{Group: name, Success: !f.failing[name]})
+		if f.failing[name] {
+			result.Success = false
+		}
+	}
+	return result
+}
+
+func (f *fakeSync) last() (SyncRun, []string) {
+	return f.runs[len(f.runs)-1], f.groups[len(f.groups)-1]
+}
+
+func TestWatcher(t *testing.T) {
+	fake := &fakeSync{failing: map[string]bool{}}
+	ports := [][2]uint16{}
+	config := Configuration{PortFile: "/tmp/a", Requests: map[string]RequestGroup{"a": {}, "b": {}}}
+	watcher := NewWatcher(config, fake.sync, func(port, previous uint16) {
+		ports = append(ports, [2]uint16{port, previous})
+	})
+
+	t.Run("Unknown port", func(t *testing.T) {
+		if err := watcher.Sync(config.Requests); !errors.Is(err, ErrPortUnknown) {
+			t.Fatalf("expected the port to be unknown but got %v", err)
+		}
+		if _, err := watcher.Command(0, nil); !errors.Is(err, ErrPortUnknown) {
+			t.Fatalf("expected the port to be unknown but got %v", err)
+		}
+		if len(fake.runs) != 0 {
+			t.Fatalf("nothing can be synchronized without a port %v", fake.groups)
+		}
+	})
+
+	t.Run("Port change", func(t *testing.T) {
+		if !watcher.SetPort(1000) || watcher.SetPort(1000) {
+			t.Fatal("only a different port is a change")
+		}
+		watcher.SetPort(1001)
+		run, groups := fake.last()
+		if len(fake.runs) != 2 || run.Port != 1001 || run.PreviousPort != 1000 || len(groups) != 2 {
+			t.Fatalf("expected every group synchronized with the new port %+v %v", run, groups)
+		}
+		if !reflect.DeepEqual(ports, [][2]uint16{{1000, 0}, {1001, 1000}}) {
+			t.Fatalf("unexpected port changes %v", ports)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		fake.failing["b"] = true
+		watcher.SetPort(1002)
+		first, _ := fake.last()
+		if pending := watcher.Pending(); len(pending) != 1 || pending[0] != "b" {
+			t.Fatalf("expected the failed group to be pending %v", pending)
+		}
+
+		fake.failing["b"] = false
+		watcher.Retry()
+		run, groups := fake.last()
+		if run.ID != first.ID || run.Attempt != 2 || run.PreviousPort != 1001 || !reflect.DeepEqual(groups, []string{"b"}) {
+			t.Fatalf("expected another attempt of the run for b %+v %v", run, groups)
+		}
+		if pending := watcher.Pending(); len(pending) != 0 {
+			t.Fatalf("nothing is pending after the retry %v", pending)
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		newConfig := Configuration{PortFile: "/tmp/b", Requests: map[string]RequestGroup{
+			"a": {},
+			"b": {ResyncInterval: time.Minute},
+			"c": {},
+		}}
+		changed := watcher.Reload(newConfig, func(file string) error {
+			return errors.New("no such directory")
+		})
+		if names := GroupNames(changed); !reflect.DeepEqual(names, []string{"b", "c"}) {
+			t.Fatalf("expected the changed and new groups %v", names)
+		}
+		if file := watcher.Config().PortFile; file != "/tmp/a" {
+			t.Fatalf("expected to keep watching the previous port file but got %s", file)
+		}
+
+		switched := ""
+		watcher.Reload(newConfig, func(file string) error {
+			switched = file
+			return nil
+		})
+		if switched != "/tmp/b" || watcher.Config().PortFile != "/tmp/b" {
+			t.Fatalf("expected to switch to the new port file %s", switched)
+		}
+	})
+
+	t.Run("Sync every group", func(t *testing.T) {
+		watcher.Sync(watcher.Config().Requests)
+		run, groups := fake.last()
+		if run.Port != 1002 || run.PreviousPort != 0 || run.Attempt != 1 || len(groups) != 3 {
+			t.Fatalf("expected a new run of every group %+v %v", run, groups)
+		}
+		if next, ok := watcher.NextResync(); !ok || time.Until(next) > time.Minute {
+			t.Fatalf("expected the resync of b to be planned %s", next)
+		}
+		if !watcher.Resync(time.Now().Add(time.Minute)) {
+			t.Fatal("expected b to be resynchronized")
+		}
+		if _, groups := fake.last(); !reflect.DeepEqual(groups, []string{"b"}) {
+			t.Fatalf("expected only b to be resynchronized %v", groups)
+		}
+	})
+
+	t.Run("Command", func(t *testing.T) {
+		runs := len(fake.runs)
+		if _, err := watcher.Command(0, []string{"x"}); !errors.Is(err, ErrUnknownGroup) || len(fake.runs) != runs {
+			t.Fatalf("expected unknown groups to be rejected %v", err)
+		}
+
+		result, err := watcher.Command(0, []string{"a"})
+		if run, _ := fake.last(); err != nil || len(result.Groups) != 1 || run.Port != 1002 || run.PreviousPort != 0 {
+			t.Fatalf("expected a to be synchronized with the current port %+v %v", run, err)
+		}
+
+		if _, err := watcher.Command(2000, []string{"a"}); !errors.Is(err, ErrGroupsWithPort) || len(fake.runs) != runs+1 {
+			t.Fatalf("expected groups to be rejected with a port %v", err)
+		}
+
+		result, err = watcher.Command(2000, nil)
+		if run, _ := fake.last(); err != nil || len(result.Groups) != 3 || run.Port != 2000 || run.PreviousPort != 1002 {
+			t.Fatalf("expected every group to be synchronized with the new port %+v %v", run, err)
+		}
+		if watcher.Port() != 2000 || ports[len(ports)-1] != [2]uint16{2000, 1002} {
+			t.Fatalf("expected the port set through the api to be recorded %v", ports)
+		}
+	})
+
+	t.Run("Command while retrying", func(t *testing.T) {
+		fake.failing["b"] = true
+		watcher.SetPort(2001)
+		change, _ := fake.last()
+
+		watcher.Command(0, []string{"a"})
+		if run, _ := fake.last(); run.ID == change.ID || run.PreviousPort != 0 {
+			t.Fatalf("expected the api sync to be a run of its own %+v", run)
+		}
+		watcher.Command(0, []string{"b"})
+
+		fake.failing["b"] = false
+		watcher.Retry()
+		run, groups := fake.last()
+		if run.ID != change.ID || run.Attempt != 2 || run.PreviousPort != 2000 || !reflect.DeepEqual(groups, []string{"b"}) {
+			t.Fatalf("expected b to be retried as part of the port change %+v %v", run, groups)
+		}
+	})
+
+	t.Run("Resync while retrying", func(t *testing.T) {
+		watcher.Reload(Configuration{PortFile: "/tmp/b", Requests: map[string]RequestGroup{
+			"a": {ResyncInterval: time.Minute},
+			"b": {ResyncInterval: time.Minute},
+		}}, nil)
+		fake.failing["b"] = true
+		watcher.SetPort(2002)
+		change, _ := fake.last()
+
+		if !watcher.Resync(time.Now().Add(time.Minute)) {
+			t.Fatal("expected a to be resynchronized")
+		}
+		run, groups := fake.last()
+		if run.ID == change.ID || run.PreviousPort != 0 || !reflect.DeepEqual(groups, []string{"a"}) {
+			t.Fatalf("expected a resync run of its own without the pending group %+v %v", run, groups)
+		}
+
+		fake.failing["b"] = false
+		watcher.Retry()
+		run, groups = fake.last()
+		if run.ID != change.ID || run.Attempt != 2 || run.PreviousPort != 2001 || !reflect.DeepEqual(groups, []string{"b"}) {
+			t.Fatalf("expected b to be retried as part of the port change %+v %v", run, groups)
+		}
+		if next, ok := watcher.NextResync(); !ok || time.Until(next) < 59*time.Second {
+			t.Fatalf("expected the resync of b to be planned again after the retry %s", next)
+		}
+	})
+}